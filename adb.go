@@ -4,148 +4,365 @@ package tokyocabinet
 // #include <tcadb.h>
 import "C"
 
-import "unsafe"
+import (
+	"context"
+	"errors"
+	"iter"
+	"unsafe"
+)
 
-// abstract database doesn't provide detailed error messages
+// on-memory databases, and a failed Open, don't provide detailed error messages
 const ERR_MSG string = "Database operation failed"
 
 type ADB struct {
-	c_db *C.TCADB
+	c_db  *C.TCADB
+	state *handle
 }
 
 func NewADB() *ADB {
 	c_db := C.tcadbnew()
-	return &ADB{c_db}
+	state := newHandle("ADB", func() { C.tcadbdel(c_db) })
+	return &ADB{c_db: c_db, state: state}
 }
 
+/* safe to call more than once; every other method fails afterwards */
 func (db *ADB) Del() {
-	C.tcadbdel(db.c_db)
+	if db.state.release() {
+		C.tcadbdel(db.c_db)
+		db.c_db = nil
+	}
+}
+
+/*
+ * the error code of the concrete database behind the handle. on-memory
+ * databases don't track error codes and always report TCEMISC, as does a
+ * handle that is not open.
+ */
+func (db *ADB) LastECode() int {
+	if db.state.live() != nil {
+		return TCINVALID
+	}
+	c_db := C.tcadbreveal(db.c_db)
+	switch C.tcadbomode(db.c_db) {
+	case C.ADBOHDB:
+		return int(C.tchdbecode((*C.TCHDB)(c_db)))
+	case C.ADBOBDB:
+		return int(C.tcbdbecode((*C.TCBDB)(c_db)))
+	case C.ADBOFDB:
+		return int(C.tcfdbecode((*C.TCFDB)(c_db)))
+	case C.ADBOTDB:
+		return int(C.tctdbecode((*C.TCTDB)(c_db)))
+	}
+	return TCEMISC
+}
+
+func (db *ADB) LastError() error {
+	if err := db.state.live(); err != nil {
+		return err
+	}
+	switch C.tcadbomode(db.c_db) {
+	case C.ADBOHDB, C.ADBOBDB, C.ADBOFDB, C.ADBOTDB:
+		code := db.LastECode()
+		return NewTokyoCabinetError(code, ECodeName(code))
+	}
+	return NewTokyoCabinetError(TCEMISC, ERR_MSG)
+}
+
+/* whether the handle is backed by an on-memory hash or tree database */
+func (db *ADB) onMemory() bool {
+	omode := C.tcadbomode(db.c_db)
+	return omode == C.ADBOMDB || omode == C.ADBONDB
 }
 
 func (db *ADB) Open(path string) (err error) {
+	if err = db.state.lockLive(); err != nil {
+		return
+	}
+	defer db.state.unlock()
 	c_path := C.CString(path)
 	defer C.free(unsafe.Pointer(c_path))
 	if !C.tcadbopen(db.c_db, c_path) {
-		err = NewTokyoCabinetError(0, ERR_MSG)
+		err = db.LastError()
+	} else {
+		db.state.open.Store(true)
 	}
 	return
 }
 
+/* closing a handle that is not open does nothing */
 func (db *ADB) Close() (err error) {
+	if db.state.lock() != nil {
+		return
+	}
+	defer db.state.unlock()
 	if !C.tcadbclose(db.c_db) {
-		err = NewTokyoCabinetError(0, ERR_MSG)
+		err = db.LastError()
+	} else {
+		db.state.open.Store(false)
 	}
 	return
 }
 
 func (db *ADB) BeginTxn() (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	return db.begin()
+}
+
+func (db *ADB) begin() (err error) {
 	if !C.tcadbtranbegin(db.c_db) {
-		err = NewTokyoCabinetError(0, ERR_MSG)
+		err = db.LastError()
 	}
 	return
 }
 
 func (db *ADB) CommitTxn() (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	return db.commit()
+}
+
+func (db *ADB) commit() (err error) {
 	if !C.tcadbtrancommit(db.c_db) {
-		err = NewTokyoCabinetError(0, ERR_MSG)
+		err = db.LastError()
 	}
 	return
 }
 
 func (db *ADB) AbortTxn() (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	return db.abort()
+}
+
+func (db *ADB) abort() (err error) {
 	if !C.tcadbtranabort(db.c_db) {
-		err = NewTokyoCabinetError(0, ERR_MSG)
+		err = db.LastError()
 	}
 	return
 }
 
+/*
+ * runs fn in a transaction holding the database exclusively. the transaction
+ * is committed if fn returns nil, and aborted if it returns an error or panics.
+ */
+func (db *ADB) Update(fn func(tx *Tx) error) error {
+	return runTx(db.state, db, true, fn)
+}
+
+/* as Update, for reads only; nothing is committed or aborted */
+func (db *ADB) View(fn func(tx *Tx) error) error {
+	return runTx(db.state, db, false, fn)
+}
+
 func (db *ADB) Put(key []byte, value []byte) (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	return db.put(key, value)
+}
+
+func (db *ADB) put(key []byte, value []byte) (err error) {
 	if !C.tcadbput(db.c_db,
-		unsafe.Pointer(&key[0]), C.int(len(key)),
-		unsafe.Pointer(&value[0]), C.int(len(value))) {
-		err = NewTokyoCabinetError(0, ERR_MSG)
+		bytesPtr(key), C.int(len(key)),
+		bytesPtr(value), C.int(len(value))) {
+		err = db.LastError()
 	}
 	return
 }
 
 func (db *ADB) PutKeep(key []byte, value []byte) (err error) {
-	// Unfortunately, ADB doesn't provide a way to check the error code
-	// ...so we can't ignore only already-present keys.
-	// ...so, we're just going to ignore *all* errors. Yeeeah.
-	C.tcadbputkeep(db.c_db,
-		unsafe.Pointer(&key[0]), C.int(len(key)),
-		unsafe.Pointer(&value[0]), C.int(len(value)))
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	if !C.tcadbputkeep(db.c_db,
+		bytesPtr(key), C.int(len(key)),
+		bytesPtr(value), C.int(len(value))) {
+		// on-memory databases only refuse a putkeep for an existing key
+		if db.onMemory() || db.LastECode() == TCEKEEP {
+			return
+		}
+		err = db.LastError()
+	}
 	return
 }
 
 func (db *ADB) PutCat(key []byte, value []byte) (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
 	if !C.tcadbputcat(db.c_db,
-		unsafe.Pointer(&key[0]), C.int(len(key)),
-		unsafe.Pointer(&value[0]), C.int(len(value))) {
-		err = NewTokyoCabinetError(0, ERR_MSG)
+		bytesPtr(key), C.int(len(key)),
+		bytesPtr(value), C.int(len(value))) {
+		err = db.LastError()
 	}
 	return
 }
 
 func (db *ADB) AddInt(key []byte, value int) (newvalue int, err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
 	res := C.tcadbaddint(db.c_db,
-		unsafe.Pointer(&key[0]), C.int(len(key)),
+		bytesPtr(key), C.int(len(key)),
 		C.int(value))
 	if res == C.INT_MIN {
-		err = NewTokyoCabinetError(0, ERR_MSG)
+		err = db.LastError()
 	}
 	newvalue = int(res)
 	return
 }
 
 func (db *ADB) AddDouble(key []byte, value float64) (newvalue float64, err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
 	res := C.tcadbadddouble(db.c_db,
-		unsafe.Pointer(&key[0]), C.int(len(key)),
+		bytesPtr(key), C.int(len(key)),
 		C.double(value))
 	if isnan(res) {
-		err = NewTokyoCabinetError(0, ERR_MSG)
+		err = db.LastError()
 	}
 	newvalue = float64(res)
 	return
 }
 
 func (db *ADB) Remove(key []byte) (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	return db.remove(key)
+}
+
+func (db *ADB) remove(key []byte) (err error) {
 	if !C.tcadbout(db.c_db,
-		unsafe.Pointer(&key[0]), C.int(len(key))) {
-		err = NewTokyoCabinetError(0, ERR_MSG)
+		bytesPtr(key), C.int(len(key))) {
+		err = db.LastError()
 	}
 	return
 }
 
 func (db *ADB) Get(key []byte) (out []byte, err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	return db.get(key)
+}
+
+func (db *ADB) get(key []byte) (out []byte, err error) {
 	var size C.int
 	rec := C.tcadbget(db.c_db,
-		unsafe.Pointer(&key[0]), C.int(len(key)),
+		bytesPtr(key), C.int(len(key)),
 		&size)
 	if rec != nil {
 		defer C.free(unsafe.Pointer(rec))
 		out = C.GoBytes(rec, size)
 	} else {
-		err = NewTokyoCabinetError(0, ERR_MSG)
+		err = db.LastError()
 	}
 	return
 }
 
+/*
+ * copies the value into buf, returning the number of bytes written. a value
+ * longer than buf is truncated; Size reports its full length.
+ */
+func (db *ADB) GetInto(key []byte, buf []byte) (n int, err error) {
+	err = db.GetFunc(key, func(value []byte) {
+		n = copy(buf, value)
+	})
+	return
+}
+
+/*
+ * passes the value to fn without copying it into Go memory. the slice is
+ * borrowed from the library and only valid for the duration of the call.
+ */
+func (db *ADB) GetFunc(key []byte, fn func(value []byte)) (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	var size C.int
+	rec := C.tcadbget(db.c_db,
+		bytesPtr(key), C.int(len(key)),
+		&size)
+	if rec == nil {
+		err = db.LastError()
+	}
+	db.state.exit()
+	if rec == nil {
+		return
+	}
+	defer C.free(rec)
+	fn(unsafe.Slice((*byte)(rec), int(size)))
+	return
+}
+
 func (db *ADB) Size(key []byte) (out int, err error) {
-	res := C.tcadbvsiz(db.c_db, unsafe.Pointer(&key[0]), C.int(len(key)))
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	res := C.tcadbvsiz(db.c_db, bytesPtr(key), C.int(len(key)))
 	if res < 0 {
-		err = NewTokyoCabinetError(0, ERR_MSG)
+		err = db.LastError()
 	} else {
 		out = int(res)
 	}
 	return
 }
 
+/* keys beginning with prefix; negative max for infinite */
+func (db *ADB) FwmKeys(prefix []byte, max int) (keys [][]byte) {
+	if db.state.enter() != nil {
+		return
+	}
+	defer db.state.exit()
+	resList := C.tcadbfwmkeys(db.c_db,
+		bytesPtr(prefix), C.int(len(prefix)),
+		C.int(max))
+	defer C.tclistdel(resList)
+	keys = goList(resList)
+	return
+}
+
+/*
+ * the caller must read c until it is closed, as the database cannot be
+ * iterated again until then; use IterKeysContext to be able to stop early.
+ * fails with ErrBusy on e if another loop over the database is running.
+ */
 func (db *ADB) IterKeys() (c chan []byte, e chan error) {
+	return db.IterKeysContext(context.Background())
+}
+
+/*
+ * like IterKeys, but stops iterating once ctx is done, reporting ctx.Err()
+ * on e. e is buffered so that a final error never blocks the iterator.
+ */
+func (db *ADB) IterKeysContext(ctx context.Context) (c chan []byte, e chan error) {
 	c = make(chan []byte)
-	e = make(chan error)
-	if !C.tcadbiterinit(db.c_db) {
-		e <- NewTokyoCabinetError(0, ERR_MSG)
+	e = make(chan error, 1)
+	err := db.state.tryBeginIter()
+	if err == nil {
+		if err = db.iterInit(); err != nil {
+			db.state.endIter()
+		}
+	}
+	if err != nil {
+		e <- err
 		close(c)
 		close(e)
 		return
@@ -153,23 +370,202 @@ func (db *ADB) IterKeys() (c chan []byte, e chan error) {
 	go func() {
 		defer close(c)
 		defer close(e)
+		defer db.state.endIter()
 		for {
-			var size C.int
-			rec := C.tcadbiternext(db.c_db, &size)
-			if rec != nil {
-				c <- C.GoBytes(rec, size)
-				C.free(rec)
-				continue
+			key, ok, err := db.iterNext()
+			if !ok {
+				if err != nil {
+					e <- err
+				}
+				return
+			}
+			select {
+			case c <- key:
+			case <-ctx.Done():
+				e <- ctx.Err()
+				return
 			}
-			break
 		}
 	}()
 	return
 }
 
+/* a loop over the records that reports the error, if any, that ended it */
+func (db *ADB) Iter() *Iterator {
+	return &Iterator{keys: db.scanKeys, records: db.scanRecords}
+}
+
+/*
+ * range-over-func iteration over every key. an error ends the loop as the
+ * last record does; loop over Iter to tell the two apart.
+ */
+func (db *ADB) Keys() iter.Seq[[]byte] {
+	return db.Iter().Keys()
+}
+
+/* as Keys, yielding each value along with its key */
+func (db *ADB) Records() iter.Seq2[[]byte, []byte] {
+	return db.Iter().Records()
+}
+
+func (db *ADB) All() iter.Seq2[[]byte, []byte] {
+	return db.Iter().All()
+}
+
+func (db *ADB) scanKeys(yield func([]byte) bool) (err error) {
+	if err = db.state.beginIter(); err != nil {
+		return
+	}
+	defer db.state.endIter()
+	if err = db.iterInit(); err != nil {
+		return
+	}
+	for {
+		key, ok, err := db.iterNext()
+		if !ok {
+			return err
+		}
+		if !yield(key) {
+			return nil
+		}
+	}
+}
+
+func (db *ADB) iterInit() (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	if !C.tcadbiterinit(db.c_db) {
+		err = db.LastError()
+	}
+	return
+}
+
+/* ok is false at the end of the records, or on error */
+func (db *ADB) iterNext() (key []byte, ok bool, err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	var size C.int
+	rec := C.tcadbiternext(db.c_db, &size)
+	if rec == nil {
+		// on-memory databases don't track error codes, and only run out
+		if !db.onMemory() && db.LastECode() != TCENOREC {
+			err = db.LastError()
+		}
+		return
+	}
+	defer C.free(rec)
+	return C.GoBytes(rec, size), true, nil
+}
+
+func (db *ADB) scanRecords(yield func([]byte, []byte) bool) (err error) {
+	keyErr := db.scanKeys(func(key []byte) bool {
+		var value []byte
+		var ok bool
+		if value, ok, err = db.lookup(key); err != nil {
+			return false
+		}
+		// records removed since the key was read are skipped
+		return !ok || yield(key, value)
+	})
+	if err == nil {
+		err = keyErr
+	}
+	return
+}
+
+/* as Get, reporting a missing record through ok rather than an error */
+func (db *ADB) lookup(key []byte) (value []byte, ok bool, err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	if value, err = db.get(key); err == nil {
+		return value, true, nil
+	}
+	// on-memory databases only fail a get for a missing record
+	if db.onMemory() || errors.Is(err, ErrNoRecord) {
+		err = nil
+	}
+	return
+}
+
+/* params are as for Open, e.g. "#bnum=1000000#opts=ld" */
+func (db *ADB) Optimize(params string) (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	c_params := C.CString(params)
+	defer C.free(unsafe.Pointer(c_params))
+	if !C.tcadboptimize(db.c_db, c_params) {
+		err = db.LastError()
+	}
+	return
+}
+
+/* removes every record */
+func (db *ADB) Vanish() (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	if !C.tcadbvanish(db.c_db) {
+		err = db.LastError()
+	}
+	return
+}
+
+/* copies the database file to path; safe to call while the database is in use */
+func (db *ADB) Copy(path string) (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	c_path := C.CString(path)
+	defer C.free(unsafe.Pointer(c_path))
+	if !C.tcadbcopy(db.c_db, c_path) {
+		err = db.LastError()
+	}
+	return
+}
+
+/* empty if the database is not open; "*" or "+" for on-memory databases */
+func (db *ADB) Path() string {
+	if db.state.enterLive() != nil {
+		return ""
+	}
+	defer db.state.exit()
+	return C.GoString(C.tcadbpath(db.c_db))
+}
+
+func (db *ADB) RecordCount() uint64 {
+	if db.state.enter() != nil {
+		return 0
+	}
+	defer db.state.exit()
+	return uint64(C.tcadbrnum(db.c_db))
+}
+
+/* file size, or memory usage for on-memory databases */
+func (db *ADB) FileSize() uint64 {
+	if db.state.enter() != nil {
+		return 0
+	}
+	defer db.state.exit()
+	return uint64(C.tcadbsize(db.c_db))
+}
+
 func (db *ADB) Sync() (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
 	if !C.tcadbsync(db.c_db) {
-		err = NewTokyoCabinetError(0, ERR_MSG)
+		err = db.LastError()
 	}
 	return
 }
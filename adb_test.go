@@ -1,7 +1,11 @@
 package tokyocabinet
 
 import "bytes"
+import "context"
+import "errors"
+import "io/ioutil"
 import "os"
+import "path/filepath"
 import "testing"
 
 func adb_assertOpen(t *testing.T, filename string) ADB {
@@ -195,3 +199,220 @@ func TestADBTransactions(t *testing.T) {
 	adb_assertCommitTxn(t, db)
 	adb_assertGetValue(t, db, "txn-2", "set-inside-txn")
 }
+
+func adb_assertFwmKeySet(t *testing.T, db ADB, prefix string, max int, expectedKeys []string) {
+	keys := db.FwmKeys([]byte(prefix), max)
+	if len(keys) != len(expectedKeys) {
+		t.Fatalf("Expected %d keys with prefix %s, found %d", len(expectedKeys), prefix, len(keys))
+	}
+	seenKeys := make(map[string]bool)
+	for _, key := range keys {
+		seenKeys[string(key)] = true
+	}
+	for i := 0; i < len(expectedKeys); i++ {
+		if !seenKeys[expectedKeys[i]] {
+			t.Fatalf("Did not see expected key %s with prefix %s", expectedKeys[i], prefix)
+		}
+	}
+}
+
+func TestADBFwmKeys(t *testing.T) {
+	db := adb_assertOpen(t, "*")
+	defer adb_assertClose(t, db)
+
+	adb_assertPut(t, db, "user:1", "alice")
+	adb_assertPut(t, db, "user:2", "bob")
+	adb_assertPut(t, db, "group:1", "staff")
+
+	adb_assertFwmKeySet(t, db, "user:", -1, []string{"user:1", "user:2"})
+	adb_assertFwmKeySet(t, db, "group:", -1, []string{"group:1"})
+	adb_assertFwmKeySet(t, db, "nobody:", -1, []string{})
+	if keys := db.FwmKeys([]byte("user:"), 1); len(keys) != 1 {
+		t.Fatalf("Expected max to limit results to 1 key, found %d", len(keys))
+	}
+}
+
+func TestADBMaintenance(t *testing.T) {
+	// Optimize replaces the file, so it must stay on disk for the whole test
+	filename := filepath.Join(t.TempDir(), "testadbmaint.tch")
+	var db ADB = *NewADB()
+	if err := db.Open(filename); err != nil {
+		t.Fatalf("Unable to open %s: %s", filename, err)
+	}
+	defer adb_assertClose(t, db)
+
+	adb_assertPut(t, db, "hello", "world")
+	adb_assertPut(t, db, "goodbye", "world")
+	if db.Path() != filename {
+		t.Fatalf("Unexpected database path %s", db.Path())
+	}
+	if n := db.RecordCount(); n != 2 {
+		t.Fatalf("Expected 2 records, found %d", n)
+	}
+	if db.FileSize() == 0 {
+		t.Fatalf("Expected a non-zero file size")
+	}
+
+	if err := db.Optimize(""); err != nil {
+		t.Fatalf("Unable to optimize database: %s", err)
+	}
+
+	tf, err := ioutil.TempFile("", "tctest")
+	if err != nil {
+		t.Fatalf("Unable to create temporary file: %s", err)
+	}
+	copyName := tf.Name() + ".tch"
+	os.Remove(tf.Name())
+	if err := db.Copy(copyName); err != nil {
+		t.Fatalf("Unable to copy database: %s", err)
+	}
+	copied := adb_assertOpen(t, copyName+"#mode=r")
+	adb_assertGetValue(t, copied, "hello", "world")
+	adb_assertClose(t, copied)
+	os.Remove(copyName)
+
+	if err := db.Vanish(); err != nil {
+		t.Fatalf("Unable to vanish database: %s", err)
+	}
+	if n := db.RecordCount(); n != 0 {
+		t.Fatalf("Expected no records after vanishing, found %d", n)
+	}
+}
+
+func TestADBIterCancel(t *testing.T) {
+	db := adb_assertOpen(t, "*")
+	defer adb_assertClose(t, db)
+
+	adb_assertPut(t, db, "a", "1")
+	adb_assertPut(t, db, "b", "2")
+	adb_assertPut(t, db, "c", "3")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result_chan, err_chan := db.IterKeysContext(ctx)
+	if _, ok := <-result_chan; !ok {
+		t.Fatalf("Expected at least one key before cancelling")
+	}
+	cancel()
+	for range result_chan {
+	}
+	if err := <-err_chan; err != nil && err != context.Canceled {
+		t.Fatalf("Unexpected error after cancelling iteration: %s", err)
+	}
+	if _, ok := <-err_chan; ok {
+		t.Fatalf("Expected error channel to be closed")
+	}
+}
+
+func TestADBRangeFunc(t *testing.T) {
+	db := adb_assertOpen(t, "*")
+	defer adb_assertClose(t, db)
+
+	adb_assertPut(t, db, "hello", "world")
+	adb_assertPut(t, db, "goodbye", "moon")
+
+	seen := make(map[string]string)
+	it := db.Iter()
+	for key, value := range it.All() {
+		seen[string(key)] = string(value)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("Error while iterating over records: %s", err)
+	}
+	if len(seen) != 2 || seen["hello"] != "world" || seen["goodbye"] != "moon" {
+		t.Fatalf("Unexpected records during iteration: %v", seen)
+	}
+
+	count := 0
+	for range db.Keys() {
+		count++
+		break
+	}
+	if count != 1 {
+		t.Fatalf("Expected to stop after 1 key, saw %d", count)
+	}
+}
+
+func TestADBErrors(t *testing.T) {
+	db := adb_assertOpen(t, "testadberr.tch")
+	defer adb_assertClose(t, db)
+
+	adb_assertPutKeep(t, db, "keep", "first")
+	adb_assertPutKeep(t, db, "keep", "second")
+	adb_assertGetValue(t, db, "keep", "first")
+
+	_, err := db.Get([]byte("missing"))
+	if !errors.Is(err, ErrNoRecord) {
+		t.Fatalf("Expected ErrNoRecord from hash-backed ADB, got %v", err)
+	}
+	if db.LastECode() != TCENOREC {
+		t.Fatalf("Expected error code %d, got %d", TCENOREC, db.LastECode())
+	}
+
+	unopened := NewADB()
+	defer unopened.Del()
+	if err := unopened.PutKeep([]byte("key"), []byte("value")); err == nil {
+		t.Fatalf("Expected an error storing into an unopened database")
+	}
+}
+
+func TestADBEmpty(t *testing.T) {
+	db := adb_assertOpen(t, "testempty.tch")
+	defer adb_assertClose(t, db)
+	adb_assertPut(t, db, "", "empty key")
+	adb_assertGetValue(t, db, "", "empty key")
+	adb_assertPut(t, db, "foo", "")
+	adb_assertGetValue(t, db, "foo", "")
+	if size := adb_assertGetSize(t, db, "foo"); size != 0 {
+		t.Fatalf("Expected an empty value, got size %d", size)
+	}
+	adb_assertPutCat(t, db, "foo", "")
+	adb_assertPutCat(t, db, "foo", "bar")
+	adb_assertGetValue(t, db, "foo", "bar")
+	adb_assertPutKeep(t, db, "bar", "")
+	if err := db.Put([]byte("nil"), nil); err != nil {
+		t.Fatalf("Unable to put a nil value: %s", err)
+	}
+	adb_assertGetValue(t, db, "nil", "")
+	adb_assertFwmKeySet(t, db, "", -1, []string{"", "foo", "bar", "nil"})
+	if err := db.Remove(nil); err != nil {
+		t.Fatalf("Unable to remove the empty key: %s", err)
+	}
+	if _, err := db.Get([]byte{}); !errors.Is(err, ErrNoRecord) {
+		t.Fatalf("Expected the empty key to be removed, got %v", err)
+	}
+}
+
+func TestADBGetInto(t *testing.T) {
+	db := adb_assertOpen(t, "testgetinto.tch")
+	defer adb_assertClose(t, db)
+	adb_assertPut(t, db, "foo", "foobar")
+	adb_assertPut(t, db, "empty", "")
+
+	buf := make([]byte, 16)
+	n, err := db.GetInto([]byte("foo"), buf)
+	if err != nil || string(buf[:n]) != "foobar" {
+		t.Fatalf("Expected foobar, got %q (%v)", buf[:n], err)
+	}
+	n, err = db.GetInto([]byte("foo"), buf[:3])
+	if err != nil || string(buf[:n]) != "foo" {
+		t.Fatalf("Expected a truncated value, got %q (%v)", buf[:n], err)
+	}
+	n, err = db.GetInto([]byte("empty"), buf)
+	if err != nil || n != 0 {
+		t.Fatalf("Expected an empty value, got %d bytes (%v)", n, err)
+	}
+	if _, err = db.GetInto([]byte("missing"), buf); !errors.Is(err, ErrNoRecord) {
+		t.Fatalf("Expected ErrNoRecord, got %v", err)
+	}
+
+	var seen string
+	err = db.GetFunc([]byte("foo"), func(value []byte) { seen = string(value) })
+	if err != nil || seen != "foobar" {
+		t.Fatalf("Expected foobar, got %q (%v)", seen, err)
+	}
+	called := false
+	err = db.GetFunc([]byte("missing"), func(value []byte) { called = true })
+	if called || !errors.Is(err, ErrNoRecord) {
+		t.Fatalf("Expected ErrNoRecord without a call, got %v", err)
+	}
+}
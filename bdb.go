@@ -5,13 +5,17 @@ package tokyocabinet
 // #include <tcbdb.h>
 import "C"
 
-import "unsafe"
+import (
+	"errors"
+	"iter"
+	"unsafe"
+)
 
 const BDBFOPEN int = C.BDBFOPEN
 const BDBFFATAL int = C.BDBFFATAL
 
 const BDBTLARGE int = C.BDBTLARGE
-const BDBTDEFLATE int = C.BDBTLARGE
+const BDBTDEFLATE int = C.BDBTDEFLATE
 const BDBTBZIP int = C.BDBTBZIP
 const BDBTTCBS int = C.BDBTTCBS
 const BDBTEXCODEC int = C.BDBTEXCODEC
@@ -28,44 +32,91 @@ func ECodeNameBDB(ecode int) string {
 }
 
 type BDB struct {
-	c_db *C.TCBDB
+	c_db  *C.TCBDB
+	state *handle
+	cmp   *callbackOp
+	codec *callbackOp
 }
 
 func NewBDB() *BDB {
 	c_db := C.tcbdbnew()
-	return &BDB{c_db}
+	cmp, codec := &callbackOp{}, &callbackOp{}
+	state := newHandle("BDB", func() {
+		C.tcbdbdel(c_db)
+		cmp.release()
+		codec.release()
+	})
+	return &BDB{c_db: c_db, state: state, cmp: cmp, codec: codec}
 }
 
+/* safe to call more than once; every other method fails afterwards */
 func (db *BDB) Del() {
-	C.tcbdbdel(db.c_db)
+	if db.state.release() {
+		C.tcbdbdel(db.c_db)
+		db.c_db = nil
+		db.cmp.release()
+		db.codec.release()
+	}
 }
 
+/* TCEINVALID once the handle has been deleted */
 func (db *BDB) LastECode() int {
+	if db.state.live() != nil {
+		return TCINVALID
+	}
 	return int(C.tcbdbecode(db.c_db))
 }
 
 func (db *BDB) LastError() error {
+	if err := db.state.live(); err != nil {
+		return err
+	}
 	code := db.LastECode()
 	return NewTokyoCabinetError(code, ECodeNameBDB(code))
 }
 
 func (db *BDB) Open(path string, omode int) (err error) {
+	if err = db.state.lockLive(); err != nil {
+		return
+	}
+	defer db.state.unlock()
 	c_path := C.CString(path)
 	defer C.free(unsafe.Pointer(c_path))
 	if !C.tcbdbopen(db.c_db, c_path, C.int(omode)) {
 		err = db.LastError()
+	} else if C.tcbdbopts(db.c_db)&C.BDBTEXCODEC != 0 && !db.codec.isSet() {
+		// the library would call through a null codec function
+		C.tcbdbclose(db.c_db)
+		err = NewTokyoCabinetError(TCINVALID, ECodeNameBDB(TCINVALID))
+	} else {
+		db.state.open.Store(true)
 	}
 	return
 }
 
+/* closing a handle that is not open does nothing */
 func (db *BDB) Close() (err error) {
+	if db.state.lock() != nil {
+		return
+	}
+	defer db.state.unlock()
 	if !C.tcbdbclose(db.c_db) {
 		err = db.LastError()
+	} else {
+		db.state.open.Store(false)
 	}
 	return
 }
 
 func (db *BDB) BeginTxn() (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	return db.begin()
+}
+
+func (db *BDB) begin() (err error) {
 	if !C.tcbdbtranbegin(db.c_db) {
 		err = db.LastError()
 	}
@@ -73,6 +124,14 @@ func (db *BDB) BeginTxn() (err error) {
 }
 
 func (db *BDB) CommitTxn() (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	return db.commit()
+}
+
+func (db *BDB) commit() (err error) {
 	if !C.tcbdbtrancommit(db.c_db) {
 		err = db.LastError()
 	}
@@ -80,25 +139,58 @@ func (db *BDB) CommitTxn() (err error) {
 }
 
 func (db *BDB) AbortTxn() (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	return db.abort()
+}
+
+func (db *BDB) abort() (err error) {
 	if !C.tcbdbtranabort(db.c_db) {
 		err = db.LastError()
 	}
 	return
 }
 
+/*
+ * runs fn in a transaction holding the database exclusively. the transaction
+ * is committed if fn returns nil, and aborted if it returns an error or panics.
+ */
+func (db *BDB) Update(fn func(tx *Tx) error) error {
+	return runTx(db.state, db, true, fn)
+}
+
+/* as Update, for reads only; nothing is committed or aborted */
+func (db *BDB) View(fn func(tx *Tx) error) error {
+	return runTx(db.state, db, false, fn)
+}
+
 func (db *BDB) Put(key []byte, value []byte) (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	return db.put(key, value)
+}
+
+func (db *BDB) put(key []byte, value []byte) (err error) {
 	if !C.tcbdbput(db.c_db,
-		unsafe.Pointer(&key[0]), C.int(len(key)),
-		unsafe.Pointer(&value[0]), C.int(len(value))) {
+		bytesPtr(key), C.int(len(key)),
+		bytesPtr(value), C.int(len(value))) {
 		err = db.LastError()
 	}
 	return
 }
 
 func (db *BDB) PutKeep(key []byte, value []byte) (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
 	if !C.tcbdbputkeep(db.c_db,
-		unsafe.Pointer(&key[0]), C.int(len(key)),
-		unsafe.Pointer(&value[0]), C.int(len(value))) {
+		bytesPtr(key), C.int(len(key)),
+		bytesPtr(value), C.int(len(value))) {
 		if db.LastECode() == TCEKEEP {
 			return
 		}
@@ -108,17 +200,69 @@ func (db *BDB) PutKeep(key []byte, value []byte) (err error) {
 }
 
 func (db *BDB) PutCat(key []byte, value []byte) (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
 	if !C.tcbdbputcat(db.c_db,
-		unsafe.Pointer(&key[0]), C.int(len(key)),
-		unsafe.Pointer(&value[0]), C.int(len(value))) {
+		bytesPtr(key), C.int(len(key)),
+		bytesPtr(value), C.int(len(value))) {
+		err = db.LastError()
+	}
+	return
+}
+
+/* stores value after any existing values for key */
+func (db *BDB) PutDup(key []byte, value []byte) (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	if !C.tcbdbputdup(db.c_db,
+		bytesPtr(key), C.int(len(key)),
+		bytesPtr(value), C.int(len(value))) {
+		err = db.LastError()
+	}
+	return
+}
+
+/* stores value ahead of any existing values for key */
+func (db *BDB) PutDupBack(key []byte, value []byte) (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	if !C.tcbdbputdupback(db.c_db,
+		bytesPtr(key), C.int(len(key)),
+		bytesPtr(value), C.int(len(value))) {
+		err = db.LastError()
+	}
+	return
+}
+
+/* stores each of values under key, keeping any existing values */
+func (db *BDB) PutList(key []byte, values [][]byte) (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	c_values := newTCList(values)
+	defer C.tclistdel(c_values)
+	if !C.tcbdbputdup3(db.c_db,
+		bytesPtr(key), C.int(len(key)),
+		c_values) {
 		err = db.LastError()
 	}
 	return
 }
 
 func (db *BDB) AddInt(key []byte, value int) (newvalue int, err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
 	res := C.tcbdbaddint(db.c_db,
-		unsafe.Pointer(&key[0]), C.int(len(key)),
+		bytesPtr(key), C.int(len(key)),
 		C.int(value))
 	if res == C.INT_MIN {
 		err = db.LastError()
@@ -128,8 +272,12 @@ func (db *BDB) AddInt(key []byte, value int) (newvalue int, err error) {
 }
 
 func (db *BDB) AddDouble(key []byte, value float64) (newvalue float64, err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
 	res := C.tcbdbadddouble(db.c_db,
-		unsafe.Pointer(&key[0]), C.int(len(key)),
+		bytesPtr(key), C.int(len(key)),
 		C.double(value))
 	if isnan(res) {
 		err = db.LastError()
@@ -139,28 +287,131 @@ func (db *BDB) AddDouble(key []byte, value float64) (newvalue float64, err error
 }
 
 func (db *BDB) Remove(key []byte) (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	return db.remove(key)
+}
+
+func (db *BDB) remove(key []byte) (err error) {
 	if !C.tcbdbout(db.c_db,
-		unsafe.Pointer(&key[0]), C.int(len(key))) {
+		bytesPtr(key), C.int(len(key))) {
 		err = db.LastError()
 	}
 	return
 }
 
-func (db *BDB) Get(key []byte) (out []byte) {
+/* removes every value stored under key */
+func (db *BDB) OutList(key []byte) (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	if !C.tcbdbout3(db.c_db,
+		bytesPtr(key), C.int(len(key))) {
+		err = db.LastError()
+	}
+	return
+}
+
+func (db *BDB) Get(key []byte) (out []byte, err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	return db.get(key)
+}
+
+func (db *BDB) get(key []byte) (out []byte, err error) {
 	var size C.int
 	rec := C.tcbdbget(db.c_db,
-		unsafe.Pointer(&key[0]), C.int(len(key)),
+		bytesPtr(key), C.int(len(key)),
 		&size)
 	if rec != nil {
 		defer C.free(unsafe.Pointer(rec))
 		out = C.GoBytes(rec, size)
+	} else {
+		err = db.LastError()
+	}
+	return
+}
+
+/*
+ * copies the first value into buf without allocating, returning the number
+ * of bytes written. a value longer than buf is truncated; Size reports its
+ * full length.
+ */
+func (db *BDB) GetInto(key []byte, buf []byte) (n int, err error) {
+	err = db.GetFunc(key, func(value []byte) {
+		n = copy(buf, value)
+	})
+	return
+}
+
+/*
+ * passes the first value to fn without copying it into Go memory. the slice
+ * is borrowed from the library and only valid for the duration of the call.
+ */
+func (db *BDB) GetFunc(key []byte, fn func(value []byte)) (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	var size C.int
+	// not tcbdbget3: its result points into the leaf cache, which another
+	// thread may evict once the native mutex lets calls run in parallel
+	rec := C.tcbdbget(db.c_db,
+		bytesPtr(key), C.int(len(key)),
+		&size)
+	if rec == nil {
+		err = db.LastError()
+	}
+	db.state.exit()
+	if rec == nil {
+		return
 	}
+	defer C.free(rec)
+	fn(unsafe.Slice((*byte)(rec), int(size)))
+	return
+}
 
+func (db *BDB) GetList(key []byte) (out [][]byte, err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	resList := C.tcbdbget4(db.c_db,
+		bytesPtr(key), C.int(len(key)))
+	if resList != nil {
+		defer C.tclistdel(resList)
+		out = goList(resList)
+	} else {
+		err = db.LastError()
+	}
+	return
+}
+
+/* number of values stored under key */
+func (db *BDB) Count(key []byte) (out int, err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	res := C.tcbdbvnum(db.c_db, bytesPtr(key), C.int(len(key)))
+	if res == 0 {
+		err = db.LastError()
+	} else {
+		out = int(res)
+	}
 	return
 }
 
 func (db *BDB) Size(key []byte) (out int, err error) {
-	res := C.tcbdbvsiz(db.c_db, unsafe.Pointer(&key[0]), C.int(len(key)))
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	res := C.tcbdbvsiz(db.c_db, bytesPtr(key), C.int(len(key)))
 	if res < 0 {
 		err = db.LastError()
 	} else {
@@ -169,52 +420,170 @@ func (db *BDB) Size(key []byte) (out int, err error) {
 	return
 }
 
+/* keys beginning with prefix; negative max for infinite */
+func (db *BDB) FwmKeys(prefix []byte, max int) (keys [][]byte) {
+	if db.state.enter() != nil {
+		return
+	}
+	defer db.state.exit()
+	resList := C.tcbdbfwmkeys(db.c_db,
+		bytesPtr(prefix), C.int(len(prefix)),
+		C.int(max))
+	defer C.tclistdel(resList)
+	keys = goList(resList)
+	return
+}
+
 /* negative max for infinite */
 func (db *BDB) Range(startKey []byte, startInclusive bool, endKey []byte,
 	endInclusive bool, max int) (keys [][]byte, err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
 
-	var startKeyLen int = 0
 	var startKeyC unsafe.Pointer
 	if startKey != nil {
-		startKeyLen = len(startKey)
-		startKeyC = unsafe.Pointer(&startKey[0])
+		startKeyC = bytesPtr(startKey)
 	}
 
-	var endKeyLen int = 0
 	var endKeyC unsafe.Pointer
 	if endKey != nil {
-		endKeyLen = len(startKey)
-		endKeyC = unsafe.Pointer(&endKey[0])
+		endKeyC = bytesPtr(endKey)
 	}
 
 	resList := C.tcbdbrange(
 		db.c_db,
-		startKeyC, C.int(startKeyLen), C.bool(startInclusive),
-		endKeyC, C.int(endKeyLen), C.bool(endInclusive),
+		startKeyC, C.int(len(startKey)), C.bool(startInclusive),
+		endKeyC, C.int(len(endKey)), C.bool(endInclusive),
 		C.int(max))
+	defer C.tclistdel(resList)
+	keys = goList(resList)
 
-	num := int(C.tclistnum(resList))
+	return
+}
+
+/* a loop over the records that reports the error, if any, that ended it */
+func (db *BDB) Iter() *Iterator {
+	return &Iterator{keys: db.scanKeys, records: db.scanRecords}
+}
 
-	keys = make([][]byte, 0)
-	for i := 0; i < num; i++ {
-		var size C.int
-		keyDat := C.tclistval(resList, C.int(i), &size)
-		var key []byte
+/*
+ * range-over-func iteration over every key in order, yielding each
+ * duplicate value's key once per value. an error ends the loop as the last
+ * record does; loop over Iter to tell the two apart.
+ */
+func (db *BDB) Keys() iter.Seq[[]byte] {
+	return db.Iter().Keys()
+}
+
+/* as Keys, yielding each value along with its key */
+func (db *BDB) Records() iter.Seq2[[]byte, []byte] {
+	return db.Iter().Records()
+}
 
-		if keyDat != nil {
-			defer C.free(unsafe.Pointer(keyDat))
-			key = C.GoBytes(keyDat, size)
-		} else {
-			panic("Failed to retrieve tclist value!")
+func (db *BDB) All() iter.Seq2[[]byte, []byte] {
+	return db.Iter().All()
+}
+
+func (db *BDB) scanKeys(yield func([]byte) bool) error {
+	return db.scanRecords(func(key []byte, value []byte) bool {
+		return yield(key)
+	})
+}
+
+func (db *BDB) scanRecords(yield func([]byte, []byte) bool) (err error) {
+	if err = db.state.beginIter(); err != nil {
+		return
+	}
+	defer db.state.endIter()
+	cur := NewBDBCursor(db)
+	defer cur.Del()
+	for err = cur.First(); err == nil; err = cur.Next() {
+		var key, value []byte
+		if key, value, err = cur.Rec(); err != nil {
+			break
+		}
+		if !yield(key, value) {
+			return nil
 		}
+	}
+	if errors.Is(err, ErrNoRecord) {
+		err = nil
+	}
+	return
+}
 
-		keys = append(keys, key)
+/* removes every record */
+func (db *BDB) Vanish() (err error) {
+	if err = db.state.enter(); err != nil {
+		return
 	}
+	defer db.state.exit()
+	if !C.tcbdbvanish(db.c_db) {
+		err = db.LastError()
+	}
+	return
+}
 
+/* copies the database file to path; safe to call while the database is in use */
+func (db *BDB) Copy(path string) (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	c_path := C.CString(path)
+	defer C.free(unsafe.Pointer(c_path))
+	if !C.tcbdbcopy(db.c_db, c_path) {
+		err = db.LastError()
+	}
 	return
 }
 
+/* the BDBT* flags the database was tuned or created with */
+func (db *BDB) Options() uint8 {
+	if db.state.enterLive() != nil {
+		return 0
+	}
+	defer db.state.exit()
+	return uint8(C.tcbdbopts(db.c_db))
+}
+
+/* the compression selected by Options */
+func (db *BDB) Compression() Compression {
+	return compressionOf(db.Options(), BDBTDEFLATE, BDBTBZIP, BDBTTCBS, BDBTEXCODEC)
+}
+
+/* empty if the database is not open */
+func (db *BDB) Path() string {
+	if db.state.enterLive() != nil {
+		return ""
+	}
+	defer db.state.exit()
+	return C.GoString(C.tcbdbpath(db.c_db))
+}
+
+func (db *BDB) RecordCount() uint64 {
+	if db.state.enter() != nil {
+		return 0
+	}
+	defer db.state.exit()
+	return uint64(C.tcbdbrnum(db.c_db))
+}
+
+func (db *BDB) FileSize() uint64 {
+	if db.state.enter() != nil {
+		return 0
+	}
+	defer db.state.exit()
+	return uint64(C.tcbdbfsiz(db.c_db))
+}
+
 func (db *BDB) Sync() (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
 	if !C.tcbdbsync(db.c_db) {
 		err = db.LastError()
 	}
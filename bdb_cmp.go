@@ -0,0 +1,85 @@
+package tokyocabinet
+
+// #cgo pkg-config: tokyocabinet
+// #include <tcbdb.h>
+// extern int goBDBCompare(char *aptr, int asiz, char *bptr, int bsiz, void *op);
+import "C"
+
+import "unsafe"
+
+const (
+	BDBCMPLEXICAL int = iota
+	BDBCMPDECIMAL
+	BDBCMPINT32
+	BDBCMPINT64
+)
+
+/*
+ * a, b are borrowed from the library and only valid for the duration of the
+ * call. the result is negative, zero or positive as a sorts before, with or
+ * after b.
+ */
+type BDBComparator func(a []byte, b []byte) int
+
+/* must be called before Open */
+func (db *BDB) SetComparator(cmp BDBComparator) (err error) {
+	if err = db.state.lockLive(); err != nil {
+		return
+	}
+	defer db.state.unlock()
+	c_op := newCallbackOp(&bdbComparison{cmp, db.state.callbacks})
+	if !C.tcbdbsetcmpfunc(db.c_db,
+		(C.TCCMP)(C.goBDBCompare),
+		c_op) {
+		freeCallbackOp(c_op)
+		return db.LastError()
+	}
+	db.cmp.replace(c_op)
+	return
+}
+
+/* selects one of the BDBCMP* orderings; must be called before Open */
+func (db *BDB) SetBuiltinComparator(cmp int) (err error) {
+	if err = db.state.lockLive(); err != nil {
+		return
+	}
+	defer db.state.unlock()
+	var c_cmp C.TCCMP
+	switch cmp {
+	case BDBCMPLEXICAL:
+		c_cmp = (C.TCCMP)(C.tccmplexical)
+	case BDBCMPDECIMAL:
+		c_cmp = (C.TCCMP)(C.tccmpdecimal)
+	case BDBCMPINT32:
+		c_cmp = (C.TCCMP)(C.tccmpint32)
+	case BDBCMPINT64:
+		c_cmp = (C.TCCMP)(C.tccmpint64)
+	default:
+		return NewTokyoCabinetError(TCINVALID, ECodeNameBDB(TCINVALID))
+	}
+	if !C.tcbdbsetcmpfunc(db.c_db, c_cmp, nil) {
+		return db.LastError()
+	}
+	db.cmp.release()
+	return
+}
+
+type bdbComparison struct {
+	cmp   BDBComparator
+	relay *panicRelay
+}
+
+//export goBDBCompare
+func goBDBCompare(aptr *C.char, asiz C.int, bptr *C.char, bsiz C.int, op unsafe.Pointer) (res C.int) {
+	c := callbackValue(op).(*bdbComparison)
+	defer c.relay.catch(func() { res = 0 })
+	a := unsafe.Slice((*byte)(unsafe.Pointer(aptr)), int(asiz))
+	b := unsafe.Slice((*byte)(unsafe.Pointer(bptr)), int(bsiz))
+	switch res := c.cmp(a, b); {
+	case res < 0:
+		return -1
+	case res > 0:
+		return 1
+	}
+	return 0
+}
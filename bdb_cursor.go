@@ -0,0 +1,216 @@
+package tokyocabinet
+
+// #cgo pkg-config: tokyocabinet
+// #include <tcbdb.h>
+import "C"
+
+import (
+	"runtime"
+	"unsafe"
+)
+
+const BDBCPCURRENT int = C.BDBCPCURRENT
+const BDBCPBEFORE int = C.BDBCPBEFORE
+const BDBCPAFTER int = C.BDBCPAFTER
+
+/*
+ * a cursor should be released with Del when it is no longer needed. its
+ * methods fail with ErrClosed once the database it was created from is
+ * closed or deleted.
+ */
+type BDBCursor struct {
+	c_cur   *C.BDBCUR
+	db      *BDB
+	cleanup runtime.Cleanup
+}
+
+func NewBDBCursor(db *BDB) *BDBCursor {
+	cur := &BDBCursor{db: db}
+	if db.state.enterLive() != nil {
+		return cur
+	}
+	defer db.state.exit()
+	cur.c_cur = C.tcbdbcurnew(db.c_db)
+	cur.cleanup = runtime.AddCleanup(cur, func(c_cur *C.BDBCUR) {
+		reportLeak("BDBCursor")
+		C.tcbdbcurdel(c_cur)
+	}, cur.c_cur)
+	return cur
+}
+
+/* safe to call more than once */
+func (cur *BDBCursor) Del() {
+	if cur.c_cur == nil {
+		return
+	}
+	cur.cleanup.Stop()
+	C.tcbdbcurdel(cur.c_cur)
+	cur.c_cur = nil
+}
+
+/* as the database's enter, failing once the cursor is deleted */
+func (cur *BDBCursor) enter() error {
+	if cur.c_cur == nil {
+		return ErrClosed
+	}
+	return cur.db.state.enter()
+}
+
+/*
+ * releases the database after enter. being deferred, it also keeps the
+ * cursor reachable until the native call is done, so the cleanup can't
+ * free it mid-call.
+ */
+func (cur *BDBCursor) exit() {
+	cur.db.state.exit()
+	runtime.KeepAlive(cur)
+}
+
+func (cur *BDBCursor) First() (err error) {
+	if err = cur.enter(); err != nil {
+		return
+	}
+	defer cur.exit()
+	if !C.tcbdbcurfirst(cur.c_cur) {
+		err = cur.db.LastError()
+	}
+	return
+}
+
+func (cur *BDBCursor) Last() (err error) {
+	if err = cur.enter(); err != nil {
+		return
+	}
+	defer cur.exit()
+	if !C.tcbdbcurlast(cur.c_cur) {
+		err = cur.db.LastError()
+	}
+	return
+}
+
+/* moves to the first record at or after key */
+func (cur *BDBCursor) Jump(key []byte) (err error) {
+	if err = cur.enter(); err != nil {
+		return
+	}
+	defer cur.exit()
+	if !C.tcbdbcurjump(cur.c_cur,
+		bytesPtr(key), C.int(len(key))) {
+		err = cur.db.LastError()
+	}
+	return
+}
+
+/* moves to the last record at or before key */
+func (cur *BDBCursor) JumpBack(key []byte) (err error) {
+	if err = cur.enter(); err != nil {
+		return
+	}
+	defer cur.exit()
+	if !C.tcbdbcurjumpback(cur.c_cur,
+		bytesPtr(key), C.int(len(key))) {
+		err = cur.db.LastError()
+	}
+	return
+}
+
+func (cur *BDBCursor) Next() (err error) {
+	if err = cur.enter(); err != nil {
+		return
+	}
+	defer cur.exit()
+	if !C.tcbdbcurnext(cur.c_cur) {
+		err = cur.db.LastError()
+	}
+	return
+}
+
+func (cur *BDBCursor) Prev() (err error) {
+	if err = cur.enter(); err != nil {
+		return
+	}
+	defer cur.exit()
+	if !C.tcbdbcurprev(cur.c_cur) {
+		err = cur.db.LastError()
+	}
+	return
+}
+
+func (cur *BDBCursor) Key() (out []byte, err error) {
+	if err = cur.enter(); err != nil {
+		return
+	}
+	defer cur.exit()
+	var size C.int
+	rec := C.tcbdbcurkey(cur.c_cur, &size)
+	if rec != nil {
+		defer C.free(unsafe.Pointer(rec))
+		out = C.GoBytes(rec, size)
+	} else {
+		err = cur.db.LastError()
+	}
+	return
+}
+
+func (cur *BDBCursor) Val() (out []byte, err error) {
+	if err = cur.enter(); err != nil {
+		return
+	}
+	defer cur.exit()
+	var size C.int
+	rec := C.tcbdbcurval(cur.c_cur, &size)
+	if rec != nil {
+		defer C.free(unsafe.Pointer(rec))
+		out = C.GoBytes(rec, size)
+	} else {
+		err = cur.db.LastError()
+	}
+	return
+}
+
+func (cur *BDBCursor) Rec() (key []byte, value []byte, err error) {
+	if err = cur.enter(); err != nil {
+		return
+	}
+	defer cur.exit()
+	c_key := C.tcxstrnew()
+	defer C.tcxstrdel(c_key)
+	c_value := C.tcxstrnew()
+	defer C.tcxstrdel(c_value)
+	if C.tcbdbcurrec(cur.c_cur, c_key, c_value) {
+		key = C.GoBytes(C.tcxstrptr(c_key), C.tcxstrsize(c_key))
+		value = C.GoBytes(C.tcxstrptr(c_value), C.tcxstrsize(c_value))
+	} else {
+		err = cur.db.LastError()
+	}
+	return
+}
+
+/*
+ * cpmode is BDBCPCURRENT to overwrite the current value, or BDBCPBEFORE or
+ * BDBCPAFTER to insert a duplicate value before or after it
+ */
+func (cur *BDBCursor) Put(value []byte, cpmode int) (err error) {
+	if err = cur.enter(); err != nil {
+		return
+	}
+	defer cur.exit()
+	if !C.tcbdbcurput(cur.c_cur,
+		bytesPtr(value), C.int(len(value)),
+		C.int(cpmode)) {
+		err = cur.db.LastError()
+	}
+	return
+}
+
+/* removes the current record; the cursor moves to the next one */
+func (cur *BDBCursor) Out() (err error) {
+	if err = cur.enter(); err != nil {
+		return
+	}
+	defer cur.exit()
+	if !C.tcbdbcurout(cur.c_cur) {
+		err = cur.db.LastError()
+	}
+	return
+}
@@ -0,0 +1,86 @@
+package tokyocabinet
+
+// #cgo pkg-config: tokyocabinet
+// #include <tcbdb.h>
+import "C"
+
+/*
+ * enables the library's own locking so that calls from several goroutines
+ * can run in parallel; without it they take turns. must be called before
+ * Open.
+ */
+func (db *BDB) SetMutex() (err error) {
+	if err = db.state.lockLive(); err != nil {
+		return
+	}
+	defer db.state.unlock()
+	if !C.tcbdbsetmutex(db.c_db) {
+		err = db.LastError()
+	} else {
+		db.state.mutex.Store(true)
+	}
+	return
+}
+
+/* opts combines BDBT* flags, e.g. uint8(BDBTLARGE)|Deflate.BDBOpts() */
+func (db *BDB) Tune(lmemb int32, nmemb int32, bnum int64, apow int8, fpow int8, opts uint8) (err error) {
+	if err = db.state.lockLive(); err != nil {
+		return
+	}
+	defer db.state.unlock()
+	if !C.tcbdbtune(db.c_db, C.int32_t(lmemb), C.int32_t(nmemb),
+		C.int64_t(bnum), C.int8_t(apow), C.int8_t(fpow), C.uint8_t(opts)) {
+		err = db.LastError()
+	}
+	return
+}
+
+func (db *BDB) SetCache(lcnum int32, ncnum int32) (err error) {
+	if err = db.state.lockLive(); err != nil {
+		return
+	}
+	defer db.state.unlock()
+	if !C.tcbdbsetcache(db.c_db, C.int32_t(lcnum), C.int32_t(ncnum)) {
+		err = db.LastError()
+	}
+	return
+}
+
+func (db *BDB) SetExtraMemorySize(xmsiz int64) (err error) {
+	if err = db.state.lockLive(); err != nil {
+		return
+	}
+	defer db.state.unlock()
+	if !C.tcbdbsetxmsiz(db.c_db, C.int64_t(xmsiz)) {
+		err = db.LastError()
+	}
+	return
+}
+
+func (db *BDB) SetDefragStepSize(dfunit int32) (err error) {
+	if err = db.state.lockLive(); err != nil {
+		return
+	}
+	defer db.state.unlock()
+	if !C.tcbdbsetdfunit(db.c_db, C.int32_t(dfunit)) {
+		err = db.LastError()
+	}
+	return
+}
+
+/*
+ * rebuilds the database file. zero or negative sizes are derived from the
+ * current record count; negative apow/fpow and opts of 0xff keep the current
+ * settings
+ */
+func (db *BDB) Optimize(lmemb int32, nmemb int32, bnum int64, apow int8, fpow int8, opts uint8) (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	if !C.tcbdboptimize(db.c_db, C.int32_t(lmemb), C.int32_t(nmemb),
+		C.int64_t(bnum), C.int8_t(apow), C.int8_t(fpow), C.uint8_t(opts)) {
+		err = db.LastError()
+	}
+	return
+}
@@ -0,0 +1,18 @@
+package tokyocabinet
+
+/*
+ * adapts a BDB to the Store interface, whose Open takes no mode: the
+ * database is opened for writing and created if need be. every other method
+ * is the BDB's own.
+ */
+type BDBStore struct {
+	*BDB
+}
+
+func NewBDBStore(db *BDB) *BDBStore {
+	return &BDBStore{db}
+}
+
+func (s *BDBStore) Open(path string) error {
+	return s.BDB.Open(path, BDBOWRITER|BDBOCREAT)
+}
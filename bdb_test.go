@@ -1,8 +1,11 @@
 package tokyocabinet
 
 import "bytes"
+import "errors"
 import "io/ioutil"
 import "os"
+import "path/filepath"
+import "sync/atomic"
 import "testing"
 
 func bdb_assertOpen(t *testing.T, filename string, flags int) BDB {
@@ -158,3 +161,474 @@ func TestBDBTransactions(t *testing.T) {
 	bdb_assertCommitTxn(t, db)
 	bdb_assertGetValue(t, db, "txn-2", "set-inside-txn")
 }
+
+func bdb_assertCursorRec(t *testing.T, cur *BDBCursor, key string, value string) {
+	k, v, err := cur.Rec()
+	if err != nil {
+		t.Fatalf("Unable to read cursor record: %s", err)
+	}
+	if string(k) != key || string(v) != value {
+		t.Fatalf("Cursor record came back incorrect (expected: %s=%s; got: %s=%s)", key, value, k, v)
+	}
+}
+
+func bdb_assertCursorKeys(t *testing.T, db BDB, expectedKeys []string) {
+	cur := NewBDBCursor(&db)
+	defer cur.Del()
+	seenKeys := make([]string, 0)
+	for err := cur.First(); err == nil; err = cur.Next() {
+		key, err := cur.Key()
+		if err != nil {
+			t.Fatalf("Unable to read cursor key: %s", err)
+		}
+		seenKeys = append(seenKeys, string(key))
+	}
+	if len(seenKeys) != len(expectedKeys) {
+		t.Fatalf("Expected %d keys, found %d during iteration", len(expectedKeys), len(seenKeys))
+	}
+	for i := 0; i < len(expectedKeys); i++ {
+		if seenKeys[i] != expectedKeys[i] {
+			t.Fatalf("Expected key %s at position %d, got %s", expectedKeys[i], i, seenKeys[i])
+		}
+	}
+}
+
+func TestBDBCursor(t *testing.T) {
+	db := bdb_assertOpen(t, "testcur.bdb", BDBOWRITER|BDBOCREAT|BDBOTRUNC)
+	defer bdb_assertClose(t, db)
+
+	bdb_assertPut(t, db, "a", "1")
+	bdb_assertPut(t, db, "c", "3")
+	bdb_assertPut(t, db, "e", "5")
+	bdb_assertCursorKeys(t, db, []string{"a", "c", "e"})
+
+	cur := NewBDBCursor(&db)
+	defer cur.Del()
+
+	if err := cur.Last(); err != nil {
+		t.Fatalf("Unable to move cursor to last record: %s", err)
+	}
+	bdb_assertCursorRec(t, cur, "e", "5")
+	if err := cur.Prev(); err != nil {
+		t.Fatalf("Unable to move cursor to previous record: %s", err)
+	}
+	bdb_assertCursorRec(t, cur, "c", "3")
+
+	if err := cur.Jump([]byte("b")); err != nil {
+		t.Fatalf("Unable to jump cursor: %s", err)
+	}
+	bdb_assertCursorRec(t, cur, "c", "3")
+	if err := cur.JumpBack([]byte("d")); err != nil {
+		t.Fatalf("Unable to jump cursor backwards: %s", err)
+	}
+	value, err := cur.Val()
+	if err != nil || string(value) != "3" {
+		t.Fatalf("Unexpected cursor value %s: %v", value, err)
+	}
+
+	if err := cur.Last(); err != nil {
+		t.Fatalf("Unable to move cursor to last record: %s", err)
+	}
+	if err := cur.Next(); err == nil {
+		t.Fatalf("Expected an error moving past the last record")
+	}
+}
+
+func TestBDBCursorModify(t *testing.T) {
+	db := bdb_assertOpen(t, "testcurmod.bdb", BDBOWRITER|BDBOCREAT|BDBOTRUNC)
+	defer bdb_assertClose(t, db)
+
+	bdb_assertPut(t, db, "a", "1")
+	bdb_assertPut(t, db, "b", "2")
+	bdb_assertPut(t, db, "c", "3")
+
+	cur := NewBDBCursor(&db)
+	defer cur.Del()
+
+	if err := cur.Jump([]byte("b")); err != nil {
+		t.Fatalf("Unable to jump cursor: %s", err)
+	}
+	if err := cur.Put([]byte("two"), BDBCPCURRENT); err != nil {
+		t.Fatalf("Unable to put through cursor: %s", err)
+	}
+	bdb_assertGetValue(t, db, "b", "two")
+
+	if err := cur.Out(); err != nil {
+		t.Fatalf("Unable to remove through cursor: %s", err)
+	}
+	bdb_assertCursorRec(t, cur, "c", "3")
+	bdb_assertCursorKeys(t, db, []string{"a", "c"})
+}
+
+func bdb_assertGetList(t *testing.T, db BDB, key string, expected []string) {
+	values, err := db.GetList([]byte(key))
+	if err != nil {
+		t.Fatalf("Unable to retrieve values for key %s: %s", key, err)
+	}
+	if len(values) != len(expected) {
+		t.Fatalf("Expected %d values for key %s, found %d", len(expected), key, len(values))
+	}
+	for i := 0; i < len(expected); i++ {
+		if bytes.Compare([]byte(expected[i]), values[i]) != 0 {
+			t.Fatalf("Value %d for key %s came back incorrect (expected: %s; got: %s)", i, key, []byte(expected[i]), values[i])
+		}
+	}
+	count, err := db.Count([]byte(key))
+	if err != nil {
+		t.Fatalf("Unable to count values for key %s: %s", key, err)
+	}
+	if count != len(expected) {
+		t.Fatalf("Expected a count of %d for key %s, got %d", len(expected), key, count)
+	}
+}
+
+func TestBDBDuplicates(t *testing.T) {
+	db := bdb_assertOpen(t, "testdup.bdb", BDBOWRITER|BDBOCREAT|BDBOTRUNC)
+	defer bdb_assertClose(t, db)
+
+	if err := db.PutDup([]byte("dup"), []byte("a")); err != nil {
+		t.Fatalf("Unable to put duplicate: %s", err)
+	}
+	if err := db.PutDup([]byte("dup"), []byte("b")); err != nil {
+		t.Fatalf("Unable to put duplicate: %s", err)
+	}
+	if err := db.PutDupBack([]byte("dup"), []byte("z")); err != nil {
+		t.Fatalf("Unable to put duplicate: %s", err)
+	}
+	bdb_assertGetList(t, db, "dup", []string{"z", "a", "b"})
+	bdb_assertGetValue(t, db, "dup", "z")
+
+	if err := db.PutList([]byte("list"), [][]byte{[]byte("x"), []byte("y")}); err != nil {
+		t.Fatalf("Unable to put list: %s", err)
+	}
+	bdb_assertGetList(t, db, "list", []string{"x", "y"})
+
+	if err := db.OutList([]byte("dup")); err != nil {
+		t.Fatalf("Unable to remove list: %s", err)
+	}
+	if _, err := db.GetList([]byte("dup")); err == nil {
+		t.Fatalf("Expected an error retrieving removed values")
+	}
+	if _, err := db.Count([]byte("dup")); err == nil {
+		t.Fatalf("Expected an error counting removed values")
+	}
+}
+
+func TestBDBComparator(t *testing.T) {
+	db := *NewBDB()
+	reverse := func(a []byte, b []byte) int {
+		return bytes.Compare(b, a)
+	}
+	if err := db.SetComparator(reverse); err != nil {
+		t.Fatalf("Unable to set comparator: %s", err)
+	}
+	tf, err := ioutil.TempFile("", "tctest")
+	if err != nil {
+		t.Fatalf("Unable to create temporary file: %s", err)
+	}
+	err = db.Open(tf.Name(), BDBOWRITER|BDBOCREAT|BDBOTRUNC)
+	os.Remove(tf.Name())
+	if err != nil {
+		t.Fatalf("Unable to open %s: %s", tf.Name(), err)
+	}
+	defer db.Del()
+	defer bdb_assertClose(t, db)
+
+	if err := db.SetComparator(reverse); err == nil {
+		t.Fatalf("Expected an error setting a comparator on an open database")
+	}
+
+	bdb_assertPut(t, db, "a", "1")
+	bdb_assertPut(t, db, "c", "3")
+	bdb_assertPut(t, db, "b", "2")
+	bdb_assertCursorKeys(t, db, []string{"c", "b", "a"})
+}
+
+func TestBDBComparatorPanic(t *testing.T) {
+	db := *NewBDB()
+	var fail atomic.Bool
+	if err := db.SetComparator(func(a []byte, b []byte) int {
+		if fail.Load() {
+			panic("failure")
+		}
+		return bytes.Compare(a, b)
+	}); err != nil {
+		t.Fatalf("Unable to set comparator: %s", err)
+	}
+	tf, err := ioutil.TempFile("", "tctest")
+	if err != nil {
+		t.Fatalf("Unable to create temporary file: %s", err)
+	}
+	err = db.Open(tf.Name(), BDBOWRITER|BDBOCREAT|BDBOTRUNC)
+	os.Remove(tf.Name())
+	if err != nil {
+		t.Fatalf("Unable to open %s: %s", tf.Name(), err)
+	}
+	defer db.Del()
+	defer bdb_assertClose(t, db)
+	bdb_assertPut(t, db, "a", "1")
+
+	func() {
+		defer func() {
+			if recover() != "failure" {
+				t.Fatalf("Expected the panic to propagate")
+			}
+		}()
+		fail.Store(true)
+		defer fail.Store(false)
+		db.Put([]byte("b"), []byte("2"))
+	}()
+	// the database must not be left locked by the panic
+	bdb_assertGetValue(t, db, "a", "1")
+}
+
+func TestBDBBuiltinComparator(t *testing.T) {
+	db := *NewBDB()
+	if err := db.SetBuiltinComparator(BDBCMPDECIMAL); err != nil {
+		t.Fatalf("Unable to set comparator: %s", err)
+	}
+	tf, err := ioutil.TempFile("", "tctest")
+	if err != nil {
+		t.Fatalf("Unable to create temporary file: %s", err)
+	}
+	err = db.Open(tf.Name(), BDBOWRITER|BDBOCREAT|BDBOTRUNC)
+	os.Remove(tf.Name())
+	if err != nil {
+		t.Fatalf("Unable to open %s: %s", tf.Name(), err)
+	}
+	defer db.Del()
+	defer bdb_assertClose(t, db)
+
+	bdb_assertPut(t, db, "10", "ten")
+	bdb_assertPut(t, db, "9", "nine")
+	bdb_assertPut(t, db, "100", "hundred")
+	bdb_assertCursorKeys(t, db, []string{"9", "10", "100"})
+}
+
+func TestBDBTune(t *testing.T) {
+	db := *NewBDB()
+	if err := db.Tune(64, 128, 4096, 8, 10, uint8(BDBTLARGE)); err != nil {
+		t.Fatalf("Unable to tune database: %s", err)
+	}
+	if err := db.SetCache(512, 256); err != nil {
+		t.Fatalf("Unable to set cache: %s", err)
+	}
+	if err := db.SetExtraMemorySize(1 << 20); err != nil {
+		t.Fatalf("Unable to set extra memory size: %s", err)
+	}
+	if err := db.SetDefragStepSize(8); err != nil {
+		t.Fatalf("Unable to set defrag step size: %s", err)
+	}
+	tf, err := ioutil.TempFile("", "tctest")
+	if err != nil {
+		t.Fatalf("Unable to create temporary file: %s", err)
+	}
+	err = db.Open(tf.Name(), BDBOWRITER|BDBOCREAT|BDBOTRUNC)
+	os.Remove(tf.Name())
+	if err != nil {
+		t.Fatalf("Unable to open %s: %s", tf.Name(), err)
+	}
+	defer db.Del()
+	defer bdb_assertClose(t, db)
+
+	bdb_assertPut(t, db, "hello", "world")
+	bdb_assertGetValue(t, db, "hello", "world")
+
+	if err := db.Tune(64, 128, 4096, 8, 10, 0); err == nil {
+		t.Fatalf("Expected an error tuning an open database")
+	}
+}
+
+func bdb_assertFwmKeySet(t *testing.T, db BDB, prefix string, max int, expectedKeys []string) {
+	keys := db.FwmKeys([]byte(prefix), max)
+	if len(keys) != len(expectedKeys) {
+		t.Fatalf("Expected %d keys with prefix %s, found %d", len(expectedKeys), prefix, len(keys))
+	}
+	seenKeys := make(map[string]bool)
+	for _, key := range keys {
+		seenKeys[string(key)] = true
+	}
+	for i := 0; i < len(expectedKeys); i++ {
+		if !seenKeys[expectedKeys[i]] {
+			t.Fatalf("Did not see expected key %s with prefix %s", expectedKeys[i], prefix)
+		}
+	}
+}
+
+func TestBDBFwmKeys(t *testing.T) {
+	db := bdb_assertOpen(t, "testfwm.bdb", BDBOWRITER|BDBOCREAT|BDBOTRUNC)
+	defer bdb_assertClose(t, db)
+
+	bdb_assertPut(t, db, "user:1", "alice")
+	bdb_assertPut(t, db, "user:2", "bob")
+	bdb_assertPut(t, db, "group:1", "staff")
+
+	bdb_assertFwmKeySet(t, db, "user:", -1, []string{"user:1", "user:2"})
+	bdb_assertFwmKeySet(t, db, "group:", -1, []string{"group:1"})
+	bdb_assertFwmKeySet(t, db, "nobody:", -1, []string{})
+	if keys := db.FwmKeys([]byte("user:"), 1); len(keys) != 1 {
+		t.Fatalf("Expected max to limit results to 1 key, found %d", len(keys))
+	}
+}
+
+func TestBDBMaintenance(t *testing.T) {
+	// Optimize replaces the file, so it must stay on disk for the whole test
+	filename := filepath.Join(t.TempDir(), "testmaint.bdb")
+	var db BDB = *NewBDB()
+	if err := db.Open(filename, BDBOWRITER|BDBOCREAT|BDBOTRUNC); err != nil {
+		t.Fatalf("Unable to open %s: %s", filename, err)
+	}
+	defer bdb_assertClose(t, db)
+
+	bdb_assertPut(t, db, "hello", "world")
+	bdb_assertPut(t, db, "goodbye", "world")
+	if db.Path() == "" {
+		t.Fatalf("Expected an open database to report its path")
+	}
+	if n := db.RecordCount(); n != 2 {
+		t.Fatalf("Expected 2 records, found %d", n)
+	}
+	if db.FileSize() == 0 {
+		t.Fatalf("Expected a non-zero file size")
+	}
+
+	if err := db.Optimize(0, 0, 0, -1, -1, 0xff); err != nil {
+		t.Fatalf("Unable to optimize database: %s", err)
+	}
+	if n := db.RecordCount(); n != 2 {
+		t.Fatalf("Expected 2 records after optimizing, found %d", n)
+	}
+
+	tf, err := ioutil.TempFile("", "tctest")
+	if err != nil {
+		t.Fatalf("Unable to create temporary file: %s", err)
+	}
+	if err := db.Copy(tf.Name()); err != nil {
+		t.Fatalf("Unable to copy database: %s", err)
+	}
+	copied := bdb_assertOpen(t, tf.Name(), BDBOREADER)
+	bdb_assertGetValue(t, copied, "hello", "world")
+	bdb_assertClose(t, copied)
+
+	if err := db.Vanish(); err != nil {
+		t.Fatalf("Unable to vanish database: %s", err)
+	}
+	if n := db.RecordCount(); n != 0 {
+		t.Fatalf("Expected no records after vanishing, found %d", n)
+	}
+}
+
+func TestBDBRangeFunc(t *testing.T) {
+	db := bdb_assertOpen(t, "testrangefunc.bdb", BDBOWRITER|BDBOCREAT|BDBOTRUNC)
+	defer bdb_assertClose(t, db)
+
+	bdb_assertPut(t, db, "hello", "world")
+	bdb_assertPut(t, db, "goodbye", "moon")
+
+	seen := make(map[string]string)
+	it := db.Iter()
+	for key, value := range it.All() {
+		seen[string(key)] = string(value)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("Error while iterating over records: %s", err)
+	}
+	if len(seen) != 2 || seen["hello"] != "world" || seen["goodbye"] != "moon" {
+		t.Fatalf("Unexpected records during iteration: %v", seen)
+	}
+
+	count := 0
+	for range db.Keys() {
+		count++
+		break
+	}
+	if count != 1 {
+		t.Fatalf("Expected to stop after 1 key, saw %d", count)
+	}
+}
+
+func TestBDBEmpty(t *testing.T) {
+	db := bdb_assertOpen(t, "testempty.bdb", BDBOWRITER|BDBOCREAT|BDBOTRUNC)
+	defer bdb_assertClose(t, db)
+	bdb_assertPut(t, db, "", "empty key")
+	bdb_assertGetValue(t, db, "", "empty key")
+	bdb_assertPut(t, db, "foo", "")
+	bdb_assertGetValue(t, db, "foo", "")
+	if size := bdb_assertGetSize(t, db, "foo"); size != 0 {
+		t.Fatalf("Expected an empty value, got size %d", size)
+	}
+	bdb_assertPutCat(t, db, "foo", "")
+	bdb_assertPutCat(t, db, "foo", "bar")
+	bdb_assertGetValue(t, db, "foo", "bar")
+	bdb_assertPutKeep(t, db, "bar", "")
+	if err := db.PutDup([]byte("bar"), nil); err != nil {
+		t.Fatalf("Unable to put a nil duplicate: %s", err)
+	}
+	bdb_assertGetList(t, db, "bar", []string{"", ""})
+	if err := db.PutList([]byte("baz"), [][]byte{{}, nil, []byte("x")}); err != nil {
+		t.Fatalf("Unable to put a list with empty values: %s", err)
+	}
+	bdb_assertGetList(t, db, "baz", []string{"", "", "x"})
+
+	keys, err := db.Range([]byte{}, true, nil, false, -1)
+	if err != nil || len(keys) != 4 || len(keys[0]) != 0 {
+		t.Fatalf("Expected every key from an empty start key, got %q (%v)", keys, err)
+	}
+	keys, err = db.Range(nil, false, []byte("baz"), false, -1)
+	if err != nil || len(keys) != 2 {
+		t.Fatalf("Expected the keys before baz, got %q (%v)", keys, err)
+	}
+
+	cur := NewBDBCursor(&db)
+	defer cur.Del()
+	if err := cur.Jump(nil); err != nil {
+		t.Fatalf("Unable to jump to the empty key: %s", err)
+	}
+	bdb_assertCursorRec(t, cur, "", "empty key")
+	if err := cur.Put(nil, BDBCPCURRENT); err != nil {
+		t.Fatalf("Unable to overwrite with an empty value: %s", err)
+	}
+	bdb_assertGetValue(t, db, "", "")
+
+	if err := db.Remove([]byte{}); err != nil {
+		t.Fatalf("Unable to remove the empty key: %s", err)
+	}
+	if _, err := db.Get(nil); !errors.Is(err, ErrNoRecord) {
+		t.Fatalf("Expected the empty key to be removed, got %v", err)
+	}
+}
+
+func TestBDBGetInto(t *testing.T) {
+	db := bdb_assertOpen(t, "testgetinto.bdb", BDBOWRITER|BDBOCREAT|BDBOTRUNC)
+	defer bdb_assertClose(t, db)
+	bdb_assertPut(t, db, "foo", "foobar")
+	bdb_assertPut(t, db, "empty", "")
+
+	buf := make([]byte, 16)
+	n, err := db.GetInto([]byte("foo"), buf)
+	if err != nil || string(buf[:n]) != "foobar" {
+		t.Fatalf("Expected foobar, got %q (%v)", buf[:n], err)
+	}
+	n, err = db.GetInto([]byte("foo"), buf[:3])
+	if err != nil || string(buf[:n]) != "foo" {
+		t.Fatalf("Expected a truncated value, got %q (%v)", buf[:n], err)
+	}
+	n, err = db.GetInto([]byte("empty"), buf)
+	if err != nil || n != 0 {
+		t.Fatalf("Expected an empty value, got %d bytes (%v)", n, err)
+	}
+	if _, err = db.GetInto([]byte("missing"), buf); !errors.Is(err, ErrNoRecord) {
+		t.Fatalf("Expected ErrNoRecord, got %v", err)
+	}
+
+	var seen string
+	err = db.GetFunc([]byte("foo"), func(value []byte) { seen = string(value) })
+	if err != nil || seen != "foobar" {
+		t.Fatalf("Expected foobar, got %q (%v)", seen, err)
+	}
+	called := false
+	err = db.GetFunc([]byte("missing"), func(value []byte) { called = true })
+	if called || !errors.Is(err, ErrNoRecord) {
+		t.Fatalf("Expected ErrNoRecord without a call, got %v", err)
+	}
+}
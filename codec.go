@@ -0,0 +1,104 @@
+package tokyocabinet
+
+// #cgo pkg-config: tokyocabinet
+// #include <tchdb.h>
+// #include <tcbdb.h>
+// extern void *goCodecEncode(void *ptr, int size, int *sp, void *op);
+// extern void *goCodecDecode(void *ptr, int size, int *sp, void *op);
+import "C"
+
+import "unsafe"
+
+/*
+ * transforms records on their way to and from the file, e.g. to compress or
+ * encrypt them. data is borrowed from the library and only valid for the
+ * duration of the call. the methods may be called from several goroutines
+ * at once.
+ */
+type Codec interface {
+	Encode(data []byte) ([]byte, error)
+	Decode(data []byte) ([]byte, error)
+}
+
+/*
+ * passes records through codec; nil removes it. must be called before Open,
+ * and only takes effect if the database is tuned with HDBTEXCODEC.
+ */
+func (db *HDB) SetCodec(codec Codec) (err error) {
+	if err = db.state.lockLive(); err != nil {
+		return
+	}
+	defer db.state.unlock()
+	enc, dec, c_op := codecFuncs(codec)
+	if !C.tchdbsetcodecfunc(db.c_db, enc, c_op, dec, c_op) {
+		if c_op != nil {
+			freeCallbackOp(c_op)
+		}
+		return db.LastError()
+	}
+	db.codec.replace(c_op)
+	return
+}
+
+/*
+ * passes pages of records through codec; nil removes it. must be called
+ * before Open, and only takes effect if the database is tuned with
+ * BDBTEXCODEC.
+ */
+func (db *BDB) SetCodec(codec Codec) (err error) {
+	if err = db.state.lockLive(); err != nil {
+		return
+	}
+	defer db.state.unlock()
+	enc, dec, c_op := codecFuncs(codec)
+	if !C.tcbdbsetcodecfunc(db.c_db, enc, c_op, dec, c_op) {
+		if c_op != nil {
+			freeCallbackOp(c_op)
+		}
+		return db.LastError()
+	}
+	db.codec.replace(c_op)
+	return
+}
+
+func codecFuncs(codec Codec) (enc C.TCCODEC, dec C.TCCODEC, c_op unsafe.Pointer) {
+	if codec == nil {
+		return
+	}
+	enc = (C.TCCODEC)(C.goCodecEncode)
+	dec = (C.TCCODEC)(C.goCodecDecode)
+	c_op = newCallbackOp(codec)
+	return
+}
+
+//export goCodecEncode
+func goCodecEncode(ptr unsafe.Pointer, size C.int, sp *C.int, op unsafe.Pointer) unsafe.Pointer {
+	return codecCall(callbackValue(op).(Codec).Encode, ptr, size, sp)
+}
+
+//export goCodecDecode
+func goCodecDecode(ptr unsafe.Pointer, size C.int, sp *C.int, op unsafe.Pointer) unsafe.Pointer {
+	return codecCall(callbackValue(op).(Codec).Decode, ptr, size, sp)
+}
+
+/*
+ * the library frees the result, so it is copied into C memory, with the
+ * trailing zero the library adds to every region it returns. an error or a
+ * panic is reported to the library as a null result.
+ */
+func codecCall(fn func([]byte) ([]byte, error), ptr unsafe.Pointer, size C.int, sp *C.int) (res unsafe.Pointer) {
+	defer func() {
+		if recover() != nil {
+			res = nil
+		}
+	}()
+	out, err := fn(unsafe.Slice((*byte)(ptr), int(size)))
+	if err != nil {
+		return nil
+	}
+	res = C.malloc(C.size_t(len(out) + 1))
+	buf := unsafe.Slice((*byte)(res), len(out)+1)
+	buf[copy(buf, out)] = 0
+	*sp = C.int(len(out))
+	return
+}
@@ -0,0 +1,154 @@
+package tokyocabinet
+
+import "bytes"
+import "errors"
+import "io/ioutil"
+import "os"
+import "strconv"
+import "sync"
+import "sync/atomic"
+import "testing"
+
+/* xors every byte, so records never reach the file as written */
+type codec_xor struct {
+	calls atomic.Int64
+	fail  atomic.Bool
+}
+
+func (c *codec_xor) apply(data []byte) ([]byte, error) {
+	c.calls.Add(1)
+	if c.fail.Load() {
+		return nil, errors.New("failure")
+	}
+	out := make([]byte, len(data))
+	for i, b := range data {
+		out[i] = b ^ 0x5a
+	}
+	return out, nil
+}
+
+func (c *codec_xor) Encode(data []byte) ([]byte, error) {
+	return c.apply(data)
+}
+
+func (c *codec_xor) Decode(data []byte) ([]byte, error) {
+	return c.apply(data)
+}
+
+func codec_assertHidden(t *testing.T, filename string, plain string) {
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Unable to read %s: %s", filename, err)
+	}
+	if bytes.Contains(contents, []byte(plain)) {
+		t.Fatalf("Expected %q to be encoded in %s", plain, filename)
+	}
+}
+
+/* put and get from several goroutines at once through the codec */
+func codec_hammer(t *testing.T, s Store) {
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				key := []byte("key" + strconv.Itoa(g*100+i))
+				if err := s.Put(key, key); err != nil {
+					t.Errorf("Unable to put %s: %s", key, err)
+					return
+				}
+				if value, err := s.Get(key); err != nil || !bytes.Equal(value, key) {
+					t.Errorf("Unexpected value for %s: %q (%v)", key, value, err)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+const codec_plain = "plaintext plaintext plaintext"
+
+func TestHDBCodec(t *testing.T) {
+	filename := options_tempName(t)
+	defer os.Remove(filename)
+	codec := &codec_xor{}
+
+	db := NewHDB()
+	defer db.Del()
+	if err := db.SetMutex(); err != nil {
+		t.Fatalf("Unable to enable the mutex: %s", err)
+	}
+	if err := db.Tune(0, -1, -1, uint8(HDBTEXCODEC)); err != nil {
+		t.Fatalf("Unable to tune database: %s", err)
+	}
+	if err := db.SetCodec(codec); err != nil {
+		t.Fatalf("Unable to set codec: %s", err)
+	}
+	if err := db.Open(filename, HDBOWRITER|HDBOCREAT|HDBOTRUNC); err != nil {
+		t.Fatalf("Unable to open %s: %s", filename, err)
+	}
+	hdb_assertPut(t, *db, "hello", codec_plain)
+	hdb_assertGetValue(t, *db, "hello", codec_plain)
+	codec_hammer(t, NewHDBStore(db))
+	if codec.calls.Load() == 0 {
+		t.Fatalf("Expected records to pass through the codec")
+	}
+
+	codec.fail.Store(true)
+	if err := db.Put([]byte("hello"), []byte("again")); err == nil {
+		t.Fatalf("Expected a failing codec to fail the put")
+	}
+	if _, err := db.Get([]byte("hello")); err == nil {
+		t.Fatalf("Expected a failing codec to fail the get")
+	}
+	codec.fail.Store(false)
+	hdb_assertClose(t, *db)
+	codec_assertHidden(t, filename, codec_plain)
+
+	if err := db.Open(filename, HDBOREADER); err != nil {
+		t.Fatalf("Unable to reopen %s: %s", filename, err)
+	}
+	hdb_assertGetValue(t, *db, "hello", codec_plain)
+	hdb_assertClose(t, *db)
+}
+
+func TestBDBCodec(t *testing.T) {
+	filename := options_tempName(t)
+	defer os.Remove(filename)
+	codec := &codec_xor{}
+
+	db := NewBDB()
+	defer db.Del()
+	if err := db.SetMutex(); err != nil {
+		t.Fatalf("Unable to enable the mutex: %s", err)
+	}
+	if err := db.Tune(0, 0, 0, -1, -1, uint8(BDBTEXCODEC)); err != nil {
+		t.Fatalf("Unable to tune database: %s", err)
+	}
+	if err := db.SetCodec(codec); err != nil {
+		t.Fatalf("Unable to set codec: %s", err)
+	}
+	if err := db.Open(filename, BDBOWRITER|BDBOCREAT|BDBOTRUNC); err != nil {
+		t.Fatalf("Unable to open %s: %s", filename, err)
+	}
+	bdb_assertPut(t, *db, "hello", codec_plain)
+	codec_hammer(t, NewBDBStore(db))
+	bdb_assertClose(t, *db)
+	if codec.calls.Load() == 0 {
+		t.Fatalf("Expected pages to pass through the codec")
+	}
+	codec_assertHidden(t, filename, codec_plain)
+
+	if err := db.Open(filename, BDBOREADER); err != nil {
+		t.Fatalf("Unable to reopen %s: %s", filename, err)
+	}
+	bdb_assertGetValue(t, *db, "hello", codec_plain)
+	bdb_assertGetValue(t, *db, "key42", "key42")
+	bdb_assertClose(t, *db)
+
+	if err := db.SetCodec(nil); err != nil {
+		t.Fatalf("Unable to remove codec: %s", err)
+	}
+}
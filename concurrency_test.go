@@ -0,0 +1,173 @@
+package tokyocabinet
+
+import "errors"
+import "os"
+import "strconv"
+import "sync"
+import "testing"
+
+const concurrency_goroutines = 8
+const concurrency_ops = 200
+
+/*
+ * hammers one handle from many goroutines; run with -race. keys are decimal
+ * so that the same workload suits FDBStore.
+ */
+func concurrency_hammer(t *testing.T, s Store, db tx_db) {
+	var wg sync.WaitGroup
+	for g := 0; g < concurrency_goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < concurrency_ops; i++ {
+				key := []byte(strconv.Itoa(g*concurrency_ops + i + 1))
+				if err := s.Put(key, key); err != nil {
+					t.Errorf("Unable to put %s: %s", key, err)
+					return
+				}
+				if value, err := s.Get(key); err != nil || string(value) != string(key) {
+					t.Errorf("Unexpected value for %s: %q (%v)", key, value, err)
+					return
+				}
+				switch i % 50 {
+				case 10:
+					if err := s.Remove(key); err != nil {
+						t.Errorf("Unable to remove %s: %s", key, err)
+						return
+					}
+				case 20:
+					n := 0
+					it := s.Iter()
+					for range it.Keys() {
+						if n++; n == 10 {
+							break
+						}
+					}
+					if err := it.Err(); err != nil {
+						t.Errorf("Error while iterating over keys: %s", err)
+						return
+					}
+				case 30:
+					err := db.Update(func(tx *Tx) error {
+						if err := tx.Put(key, []byte("updated")); err != nil {
+							return err
+						}
+						value, err := tx.Get(key)
+						if err == nil && string(value) != "updated" {
+							t.Errorf("Unexpected value for %s inside a transaction: %q", key, value)
+						}
+						return err
+					})
+					if err != nil {
+						t.Errorf("Unable to update %s: %s", key, err)
+						return
+					}
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	count := 0
+	it := s.Iter()
+	for range it.Keys() {
+		count++
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("Error while iterating over keys: %s", err)
+	}
+	expected := concurrency_goroutines * (concurrency_ops - concurrency_ops/50)
+	if count != expected {
+		t.Fatalf("Expected %d keys, found %d", expected, count)
+	}
+}
+
+func TestConcurrency(t *testing.T) {
+	for _, opts := range [][]Option{
+		{Create(), Truncate()},
+		{Create(), Truncate(), ThreadSafe()},
+	} {
+		hdbname := options_tempName(t)
+		defer os.Remove(hdbname)
+		hdb, err := OpenHDB(hdbname, opts...)
+		if err != nil {
+			t.Fatalf("Unable to open %s: %s", hdbname, err)
+		}
+		concurrency_hammer(t, NewHDBStore(hdb), hdb)
+		hdb_assertClose(t, *hdb)
+		hdb.Del()
+
+		bdbname := options_tempName(t)
+		defer os.Remove(bdbname)
+		bdb, err := OpenBDB(bdbname, opts...)
+		if err != nil {
+			t.Fatalf("Unable to open %s: %s", bdbname, err)
+		}
+		concurrency_hammer(t, NewBDBStore(bdb), bdb)
+		bdb_assertClose(t, *bdb)
+		bdb.Del()
+
+		fdbname := options_tempName(t)
+		defer os.Remove(fdbname)
+		fdb, err := OpenFDB(fdbname, opts...)
+		if err != nil {
+			t.Fatalf("Unable to open %s: %s", fdbname, err)
+		}
+		concurrency_hammer(t, NewFDBStore(fdb), fdb)
+		fdb_assertClose(t, *fdb)
+		fdb.Del()
+	}
+
+	adb := adb_assertOpen(t, "testconcurrency.tch")
+	concurrency_hammer(t, &adb, &adb)
+	adb_assertClose(t, adb)
+}
+
+func TestConcurrentClose(t *testing.T) {
+	db := hdb_assertOpen(t, "", HDBOWRITER|HDBOCREAT|HDBOTRUNC)
+	var wg sync.WaitGroup
+	for g := 0; g < concurrency_goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < concurrency_ops; i++ {
+				// fails with ErrClosed once the database is closed below
+				db.Put([]byte("key"), []byte("value"))
+			}
+		}()
+	}
+	hdb_assertClose(t, db)
+	db.Del()
+	wg.Wait()
+}
+
+/* the native mutex keeps error codes per thread; each call must see its own */
+func TestConcurrentErrorCodes(t *testing.T) {
+	filename := options_tempName(t)
+	defer os.Remove(filename)
+	db, err := OpenHDB(filename, Create(), Truncate(), ThreadSafe())
+	if err != nil {
+		t.Fatalf("Unable to open %s: %s", filename, err)
+	}
+	defer db.Del()
+	hdb_assertPut(t, *db, "key", "value")
+	var wg sync.WaitGroup
+	for g := 0; g < concurrency_goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < concurrency_ops; i++ {
+				if err := db.PutKeep([]byte("key"), []byte("other")); err != nil {
+					t.Errorf("Expected PutKeep to keep the existing record, got %s", err)
+					return
+				}
+				if _, err := db.Get([]byte("missing")); !errors.Is(err, ErrNoRecord) {
+					t.Errorf("Expected ErrNoRecord, got %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	hdb_assertClose(t, *db)
+}
@@ -13,6 +13,48 @@ func NewTokyoCabinetError(code int, msg string) *TokyoCabinetError {
 	return &TokyoCabinetError{code, msg}
 }
 
+/* sentinels for use with errors.Is; they match any error with the same code */
+var (
+	ErrThread   = NewTokyoCabinetError(TCETHREAD, ECodeName(TCETHREAD))
+	ErrInvalid  = NewTokyoCabinetError(TCINVALID, ECodeName(TCINVALID))
+	ErrNoFile   = NewTokyoCabinetError(TCENOFILE, ECodeName(TCENOFILE))
+	ErrNoPerm   = NewTokyoCabinetError(TCENOPERM, ECodeName(TCENOPERM))
+	ErrMeta     = NewTokyoCabinetError(TCEMETA, ECodeName(TCEMETA))
+	ErrRHead    = NewTokyoCabinetError(TCERHEAD, ECodeName(TCERHEAD))
+	ErrOpen     = NewTokyoCabinetError(TCEOPEN, ECodeName(TCEOPEN))
+	ErrClose    = NewTokyoCabinetError(TCECLOSE, ECodeName(TCECLOSE))
+	ErrTrunc    = NewTokyoCabinetError(TCETRUNC, ECodeName(TCETRUNC))
+	ErrSync     = NewTokyoCabinetError(TCESYNC, ECodeName(TCESYNC))
+	ErrStat     = NewTokyoCabinetError(TCESTAT, ECodeName(TCESTAT))
+	ErrSeek     = NewTokyoCabinetError(TCESEEK, ECodeName(TCESEEK))
+	ErrRead     = NewTokyoCabinetError(TCEREAD, ECodeName(TCEREAD))
+	ErrWrite    = NewTokyoCabinetError(TCEWRITE, ECodeName(TCEWRITE))
+	ErrMMap     = NewTokyoCabinetError(TCEMMAP, ECodeName(TCEMMAP))
+	ErrLock     = NewTokyoCabinetError(TCELOCK, ECodeName(TCELOCK))
+	ErrUnlink   = NewTokyoCabinetError(TCEUNLINK, ECodeName(TCEUNLINK))
+	ErrRename   = NewTokyoCabinetError(TCERENAME, ECodeName(TCERENAME))
+	ErrMkdir    = NewTokyoCabinetError(TCEMKDIR, ECodeName(TCEMKDIR))
+	ErrRmdir    = NewTokyoCabinetError(TCERMDIR, ECodeName(TCERMDIR))
+	ErrKeep     = NewTokyoCabinetError(TCEKEEP, ECodeName(TCEKEEP))
+	ErrNoRecord = NewTokyoCabinetError(TCENOREC, ECodeName(TCENOREC))
+	ErrMisc     = NewTokyoCabinetError(TCEMISC, ECodeName(TCEMISC))
+)
+
 func (e TokyoCabinetError) Error() string {
-	return fmt.Sprintf("TokyoCabinet error (%q) %q", string(e.code), string(e.msg))
+	return fmt.Sprintf("TokyoCabinet error (%d) %q", e.code, e.msg)
+}
+
+/* one of the TCE* constants */
+func (e TokyoCabinetError) Code() int {
+	return e.code
+}
+
+func (e TokyoCabinetError) Is(target error) bool {
+	switch t := target.(type) {
+	case *TokyoCabinetError:
+		return t != nil && t.code == e.code
+	case TokyoCabinetError:
+		return t.code == e.code
+	}
+	return false
 }
@@ -0,0 +1,45 @@
+package tokyocabinet
+
+import "errors"
+import "fmt"
+import "strings"
+import "testing"
+
+func TestErrorFormat(t *testing.T) {
+	err := NewTokyoCabinetError(TCENOREC, ECodeName(TCENOREC))
+	if !strings.Contains(err.Error(), fmt.Sprintf("(%d)", TCENOREC)) {
+		t.Fatalf("Expected error code to be formatted as a number: %s", err)
+	}
+	if err.Code() != TCENOREC {
+		t.Fatalf("Expected code %d, got %d", TCENOREC, err.Code())
+	}
+}
+
+func TestErrorIs(t *testing.T) {
+	hdb := hdb_assertOpen(t, "testerr.hdb", HDBOWRITER|HDBOCREAT|HDBOTRUNC)
+	defer hdb_assertClose(t, hdb)
+	if _, err := hdb.Get([]byte("missing")); !errors.Is(err, ErrNoRecord) {
+		t.Fatalf("Expected ErrNoRecord from HDB, got %v", err)
+	}
+
+	bdb := bdb_assertOpen(t, "testerr.bdb", BDBOWRITER|BDBOCREAT|BDBOTRUNC)
+	defer bdb_assertClose(t, bdb)
+	if _, err := bdb.Get([]byte("missing")); !errors.Is(err, ErrNoRecord) {
+		t.Fatalf("Expected ErrNoRecord from BDB, got %v", err)
+	}
+
+	fdb := fdb_assertOpen(t, "testerr.fdb", FDBOWRITER|FDBOCREAT|FDBOTRUNC)
+	defer fdb_assertClose(t, fdb)
+	if _, err := fdb.Get(1); !errors.Is(err, ErrNoRecord) {
+		t.Fatalf("Expected ErrNoRecord from FDB, got %v", err)
+	}
+	err := fdb.Remove(1)
+	if errors.Is(err, ErrKeep) || !errors.Is(err, ErrNoRecord) {
+		t.Fatalf("Expected only ErrNoRecord from FDB, got %v", err)
+	}
+
+	var tcerr *TokyoCabinetError
+	if !errors.As(err, &tcerr) || tcerr.Code() != TCENOREC {
+		t.Fatalf("Expected errors.As to expose the error code, got %v", err)
+	}
+}
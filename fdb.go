@@ -5,7 +5,12 @@ package tokyocabinet
 // #include <tcfdb.h>
 import "C"
 
-import "unsafe"
+import (
+	"context"
+	"errors"
+	"iter"
+	"unsafe"
+)
 
 const FDBFOPEN int = C.FDBFOPEN
 const FDBFFATAL int = C.FDBFFATAL
@@ -22,44 +27,78 @@ func ECodeNameFDB(ecode int) string {
 }
 
 type FDB struct {
-	c_db *C.TCFDB
+	c_db  *C.TCFDB
+	state *handle
 }
 
 func NewFDB() *FDB {
 	c_db := C.tcfdbnew()
-	return &FDB{c_db}
+	state := newHandle("FDB", func() { C.tcfdbdel(c_db) })
+	return &FDB{c_db: c_db, state: state}
 }
 
+/* safe to call more than once; every other method fails afterwards */
 func (db *FDB) Del() {
-	C.tcfdbdel(db.c_db)
+	if db.state.release() {
+		C.tcfdbdel(db.c_db)
+		db.c_db = nil
+	}
 }
 
+/* TCEINVALID once the handle has been deleted */
 func (db *FDB) LastECode() int {
+	if db.state.live() != nil {
+		return TCINVALID
+	}
 	return int(C.tcfdbecode(db.c_db))
 }
 
 func (db *FDB) LastError() error {
+	if err := db.state.live(); err != nil {
+		return err
+	}
 	code := db.LastECode()
 	return NewTokyoCabinetError(code, ECodeNameFDB(code))
 }
 
 func (db *FDB) Open(path string, omode int) (err error) {
+	if err = db.state.lockLive(); err != nil {
+		return
+	}
+	defer db.state.unlock()
 	c_path := C.CString(path)
 	defer C.free(unsafe.Pointer(c_path))
 	if !C.tcfdbopen(db.c_db, c_path, C.int(omode)) {
 		err = db.LastError()
+	} else {
+		db.state.open.Store(true)
 	}
 	return
 }
 
+/* closing a handle that is not open does nothing */
 func (db *FDB) Close() (err error) {
+	if db.state.lock() != nil {
+		return
+	}
+	defer db.state.unlock()
 	if !C.tcfdbclose(db.c_db) {
 		err = db.LastError()
+	} else {
+		db.state.open.Store(false)
 	}
 	return
 }
 
 func (db *FDB) BeginTxn() (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	return db.begin()
+}
+
+func (db *FDB) begin() (err error) {
 	if !C.tcfdbtranbegin(db.c_db) {
 		err = db.LastError()
 	}
@@ -67,6 +106,14 @@ func (db *FDB) BeginTxn() (err error) {
 }
 
 func (db *FDB) CommitTxn() (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	return db.commit()
+}
+
+func (db *FDB) commit() (err error) {
 	if !C.tcfdbtrancommit(db.c_db) {
 		err = db.LastError()
 	}
@@ -74,25 +121,54 @@ func (db *FDB) CommitTxn() (err error) {
 }
 
 func (db *FDB) AbortTxn() (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	return db.abort()
+}
+
+func (db *FDB) abort() (err error) {
 	if !C.tcfdbtranabort(db.c_db) {
 		err = db.LastError()
 	}
 	return
 }
 
+/*
+ * runs fn in a transaction holding the database exclusively. the transaction
+ * is committed if fn returns nil, and aborted if it returns an error or panics.
+ */
+func (db *FDB) Update(fn func(tx *Tx) error) error {
+	return runTx(db.state, &FDBStore{db}, true, fn)
+}
+
+/* as Update, for reads only; nothing is committed or aborted */
+func (db *FDB) View(fn func(tx *Tx) error) error {
+	return runTx(db.state, &FDBStore{db}, false, fn)
+}
+
 func (db *FDB) Put(key int64, value []byte) (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
 	if !C.tcfdbput(db.c_db,
 		C.int64_t(key),
-		unsafe.Pointer(&value[0]), C.int(len(value))) {
+		bytesPtr(value), C.int(len(value))) {
 		err = db.LastError()
 	}
 	return
 }
 
 func (db *FDB) PutKeep(key int64, value []byte) (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
 	if !C.tcfdbputkeep(db.c_db,
 		C.int64_t(key),
-		unsafe.Pointer(&value[0]), C.int(len(value))) {
+		bytesPtr(value), C.int(len(value))) {
 		if db.LastECode() == TCEKEEP {
 			return
 		}
@@ -102,15 +178,23 @@ func (db *FDB) PutKeep(key int64, value []byte) (err error) {
 }
 
 func (db *FDB) PutCat(key int64, value []byte) (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
 	if !C.tcfdbputcat(db.c_db,
 		C.int64_t(key),
-		unsafe.Pointer(&value[0]), C.int(len(value))) {
+		bytesPtr(value), C.int(len(value))) {
 		err = db.LastError()
 	}
 	return
 }
 
 func (db *FDB) AddInt(key int64, value int) (newvalue int, err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
 	res := C.tcfdbaddint(db.c_db,
 		C.int64_t(key),
 		C.int(value))
@@ -122,6 +206,10 @@ func (db *FDB) AddInt(key int64, value int) (newvalue int, err error) {
 }
 
 func (db *FDB) AddDouble(key int64, value float64) (newvalue float64, err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
 	res := C.tcfdbadddouble(db.c_db,
 		C.int64_t(key),
 		C.double(value))
@@ -133,6 +221,10 @@ func (db *FDB) AddDouble(key int64, value float64) (newvalue float64, err error)
 }
 
 func (db *FDB) Remove(key int64) (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
 	if !C.tcfdbout(db.c_db, C.int64_t(key)) {
 		err = db.LastError()
 	}
@@ -140,6 +232,10 @@ func (db *FDB) Remove(key int64) (err error) {
 }
 
 func (db *FDB) Get(key int64) (out []byte, err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
 	var size C.int
 	rec := C.tcfdbget(db.c_db, C.int64_t(key), &size)
 	if rec != nil {
@@ -151,7 +247,51 @@ func (db *FDB) Get(key int64) (out []byte, err error) {
 	return
 }
 
+/*
+ * copies the value into buf without allocating, returning the number of bytes
+ * written. a value longer than buf is truncated; Size reports its full length.
+ */
+func (db *FDB) GetInto(key int64, buf []byte) (n int, err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	res := C.tcfdbget4(db.c_db, C.int64_t(key), bytesPtr(buf), C.int(len(buf)))
+	if res < 0 {
+		err = db.LastError()
+	} else {
+		n = int(res)
+	}
+	return
+}
+
+/*
+ * passes the value to fn without copying it into Go memory. the slice is
+ * borrowed from the library and only valid for the duration of the call.
+ */
+func (db *FDB) GetFunc(key int64, fn func(value []byte)) (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	var size C.int
+	rec := C.tcfdbget(db.c_db, C.int64_t(key), &size)
+	if rec == nil {
+		err = db.LastError()
+	}
+	db.state.exit()
+	if rec == nil {
+		return
+	}
+	defer C.free(rec)
+	fn(unsafe.Slice((*byte)(rec), int(size)))
+	return
+}
+
 func (db *FDB) Size(key int64) (out int, err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
 	res := C.tcfdbvsiz(db.c_db, C.int64_t(key))
 	if res < 0 {
 		err = db.LastError()
@@ -161,12 +301,30 @@ func (db *FDB) Size(key int64) (out int, err error) {
 	return
 }
 
-/* note that only one iterator can be active at a time for a given database */
+/*
+ * the caller must read c until it is closed, as the database cannot be
+ * iterated again until then; use IterKeysContext to be able to stop early.
+ * fails with ErrBusy on e if another loop over the database is running.
+ */
 func (db *FDB) IterKeys() (c chan int64, e chan error) {
+	return db.IterKeysContext(context.Background())
+}
+
+/*
+ * like IterKeys, but stops iterating once ctx is done, reporting ctx.Err()
+ * on e. e is buffered so that a final error never blocks the iterator.
+ */
+func (db *FDB) IterKeysContext(ctx context.Context) (c chan int64, e chan error) {
 	c = make(chan int64)
-	e = make(chan error)
-	if !C.tcfdbiterinit(db.c_db) {
-		e <- db.LastError()
+	e = make(chan error, 1)
+	err := db.state.tryBeginIter()
+	if err == nil {
+		if err = db.iterInit(); err != nil {
+			db.state.endIter()
+		}
+	}
+	if err != nil {
+		e <- err
 		close(c)
 		close(e)
 		return
@@ -174,22 +332,215 @@ func (db *FDB) IterKeys() (c chan int64, e chan error) {
 	go func() {
 		defer close(c)
 		defer close(e)
+		defer db.state.endIter()
 		for {
-			rec := C.tcfdbiternext(db.c_db)
-			if rec != 0 {
-				c <- int64(rec)
-				continue
+			key, ok, err := db.iterNext()
+			if !ok {
+				if err != nil {
+					e <- err
+				}
+				return
 			}
-			if db.LastECode() != TCENOREC {
-				e <- db.LastError()
+			select {
+			case c <- key:
+			case <-ctx.Done():
+				e <- ctx.Err()
+				return
 			}
-			break
 		}
 	}()
 	return
 }
 
+/* a loop over the records that reports the error, if any, that ended it */
+func (db *FDB) Iter() *Iterator {
+	return &Iterator{keys: db.scanKeys, records: db.scanRecords}
+}
+
+/*
+ * range-over-func iteration over every key, as a decimal string like the
+ * keys of FDBStore. an error ends the loop as the last record does; loop
+ * over Iter to tell the two apart.
+ */
+func (db *FDB) Keys() iter.Seq[[]byte] {
+	return db.Iter().Keys()
+}
+
+/* as Keys, yielding each value along with its key */
+func (db *FDB) Records() iter.Seq2[[]byte, []byte] {
+	return db.Iter().Records()
+}
+
+func (db *FDB) All() iter.Seq2[[]byte, []byte] {
+	return db.Iter().All()
+}
+
+func (db *FDB) scanKeys(yield func([]byte) bool) (err error) {
+	if err = db.state.beginIter(); err != nil {
+		return
+	}
+	defer db.state.endIter()
+	if err = db.iterInit(); err != nil {
+		return
+	}
+	for {
+		key, ok, err := db.iterNext2()
+		if !ok {
+			return err
+		}
+		if !yield(key) {
+			return nil
+		}
+	}
+}
+
+func (db *FDB) iterInit() (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	if !C.tcfdbiterinit(db.c_db) {
+		err = db.LastError()
+	}
+	return
+}
+
+/* as Get, with the ID as a decimal string or one of FDBStore's names */
+func (db *FDB) get2(key []byte) (out []byte, err error) {
+	var size C.int
+	rec := C.tcfdbget2(db.c_db,
+		bytesPtr(key), C.int(len(key)),
+		&size)
+	if rec != nil {
+		defer C.free(unsafe.Pointer(rec))
+		out = C.GoBytes(rec, size)
+	} else {
+		err = db.LastError()
+	}
+	return
+}
+
+/* as get2, reporting a missing record through ok rather than an error */
+func (db *FDB) lookup2(key []byte) (value []byte, ok bool, err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	if value, err = db.get2(key); err == nil {
+		return value, true, nil
+	}
+	if errors.Is(err, ErrNoRecord) {
+		err = nil
+	}
+	return
+}
+
+/* as iterNext, with the ID as a decimal string */
+func (db *FDB) iterNext2() (key []byte, ok bool, err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	var size C.int
+	rec := C.tcfdbiternext2(db.c_db, &size)
+	if rec == nil {
+		if db.LastECode() != TCENOREC {
+			err = db.LastError()
+		}
+		return
+	}
+	defer C.free(rec)
+	return C.GoBytes(rec, size), true, nil
+}
+
+/* ok is false at the end of the records, or on error */
+func (db *FDB) iterNext() (key int64, ok bool, err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	rec := C.tcfdbiternext(db.c_db)
+	if rec == 0 {
+		if db.LastECode() != TCENOREC {
+			err = db.LastError()
+		}
+		return
+	}
+	return int64(rec), true, nil
+}
+
+func (db *FDB) scanRecords(yield func([]byte, []byte) bool) (err error) {
+	keyErr := db.scanKeys(func(key []byte) bool {
+		var value []byte
+		var ok bool
+		if value, ok, err = db.lookup2(key); err != nil {
+			return false
+		}
+		// records removed since the key was read are skipped
+		return !ok || yield(key, value)
+	})
+	if err == nil {
+		err = keyErr
+	}
+	return
+}
+
+/* removes every record */
+func (db *FDB) Vanish() (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	if !C.tcfdbvanish(db.c_db) {
+		err = db.LastError()
+	}
+	return
+}
+
+/* copies the database file to path; safe to call while the database is in use */
+func (db *FDB) Copy(path string) (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	c_path := C.CString(path)
+	defer C.free(unsafe.Pointer(c_path))
+	if !C.tcfdbcopy(db.c_db, c_path) {
+		err = db.LastError()
+	}
+	return
+}
+
+/* empty if the database is not open */
+func (db *FDB) Path() string {
+	if db.state.enterLive() != nil {
+		return ""
+	}
+	defer db.state.exit()
+	return C.GoString(C.tcfdbpath(db.c_db))
+}
+
+func (db *FDB) RecordCount() uint64 {
+	if db.state.enter() != nil {
+		return 0
+	}
+	defer db.state.exit()
+	return uint64(C.tcfdbrnum(db.c_db))
+}
+
+func (db *FDB) FileSize() uint64 {
+	if db.state.enter() != nil {
+		return 0
+	}
+	defer db.state.exit()
+	return uint64(C.tcfdbfsiz(db.c_db))
+}
+
 func (db *FDB) Sync() (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
 	if !C.tcfdbsync(db.c_db) {
 		err = db.LastError()
 	}
@@ -0,0 +1,105 @@
+package tokyocabinet
+
+// #cgo pkg-config: tokyocabinet
+// #include <tcfdb.h>
+import "C"
+
+import (
+	"strconv"
+	"unsafe"
+)
+
+/* special IDs accepted by Put and friends in place of a concrete key */
+const FDBIDMIN int64 = C.FDBIDMIN
+const FDBIDPREV int64 = C.FDBIDPREV
+const FDBIDMAX int64 = C.FDBIDMAX
+const FDBIDNEXT int64 = C.FDBIDNEXT
+
+/*
+ * enables the library's own locking so that calls from several goroutines
+ * can run in parallel; without it they take turns. must be called before
+ * Open.
+ */
+func (db *FDB) SetMutex() (err error) {
+	if err = db.state.lockLive(); err != nil {
+		return
+	}
+	defer db.state.unlock()
+	if !C.tcfdbsetmutex(db.c_db) {
+		err = db.LastError()
+	} else {
+		db.state.mutex.Store(true)
+	}
+	return
+}
+
+func (db *FDB) Tune(width int32, limsiz int64) (err error) {
+	if err = db.state.lockLive(); err != nil {
+		return
+	}
+	defer db.state.unlock()
+	if !C.tcfdbtune(db.c_db, C.int32_t(width), C.int64_t(limsiz)) {
+		err = db.LastError()
+	}
+	return
+}
+
+/* IDs between lower and upper inclusive; negative max for infinite */
+func (db *FDB) Range(lower int64, upper int64, max int) (ids []int64, err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	var num C.int
+	res := C.tcfdbrange(db.c_db, C.int64_t(lower), C.int64_t(upper), C.int(max), &num)
+	if res == nil {
+		err = db.LastError()
+		return
+	}
+	defer C.free(unsafe.Pointer(res))
+	ids = make([]int64, 0, int(num))
+	for _, id := range unsafe.Slice(res, int(num)) {
+		ids = append(ids, int64(id))
+	}
+	return
+}
+
+/*
+ * IDs within an interval such as "[1,10]" or "[min,max]"; negative max for
+ * infinite
+ */
+func (db *FDB) RangeString(interval string, max int) (ids []int64, err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	c_interval := []byte(interval)
+	resList := C.tcfdbrange4(db.c_db, bytesPtr(c_interval), C.int(len(c_interval)), C.int(max))
+	if resList == nil {
+		err = db.LastError()
+		return
+	}
+	defer C.tclistdel(resList)
+	keys := goList(resList)
+	ids = make([]int64, 0, len(keys))
+	for _, key := range keys {
+		id, perr := strconv.ParseInt(string(key), 10, 64)
+		if perr != nil {
+			return nil, perr
+		}
+		ids = append(ids, id)
+	}
+	return
+}
+
+/* rebuilds the database file; zero or negative arguments keep current settings */
+func (db *FDB) Optimize(width int32, limsiz int64) (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	if !C.tcfdboptimize(db.c_db, C.int32_t(width), C.int64_t(limsiz)) {
+		err = db.LastError()
+	}
+	return
+}
@@ -0,0 +1,165 @@
+package tokyocabinet
+
+// #cgo pkg-config: tokyocabinet
+// #include <tcfdb.h>
+import "C"
+
+import "iter"
+
+/*
+ * adapts an FDB to the Store interface. keys are decimal strings, or one of
+ * "min", "max", "prev" and "next" in place of the FDBID* constants.
+ */
+type FDBStore struct {
+	db *FDB
+}
+
+func NewFDBStore(db *FDB) *FDBStore {
+	return &FDBStore{db}
+}
+
+/* the wrapped database */
+func (s *FDBStore) FDB() *FDB {
+	return s.db
+}
+
+/* opens the database for writing, creating it if need be */
+func (s *FDBStore) Open(path string) error {
+	return s.db.Open(path, FDBOWRITER|FDBOCREAT)
+}
+
+func (s *FDBStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *FDBStore) BeginTxn() error {
+	return s.db.BeginTxn()
+}
+
+func (s *FDBStore) CommitTxn() error {
+	return s.db.CommitTxn()
+}
+
+func (s *FDBStore) AbortTxn() error {
+	return s.db.AbortTxn()
+}
+
+func (s *FDBStore) begin() error {
+	return s.db.begin()
+}
+
+func (s *FDBStore) commit() error {
+	return s.db.commit()
+}
+
+func (s *FDBStore) abort() error {
+	return s.db.abort()
+}
+
+func (s *FDBStore) Put(key []byte, value []byte) (err error) {
+	if err = s.db.state.enter(); err != nil {
+		return
+	}
+	defer s.db.state.exit()
+	return s.put(key, value)
+}
+
+func (s *FDBStore) put(key []byte, value []byte) (err error) {
+	if !C.tcfdbput2(s.db.c_db,
+		bytesPtr(key), C.int(len(key)),
+		bytesPtr(value), C.int(len(value))) {
+		err = s.db.LastError()
+	}
+	return
+}
+
+func (s *FDBStore) PutKeep(key []byte, value []byte) (err error) {
+	if err = s.db.state.enter(); err != nil {
+		return
+	}
+	defer s.db.state.exit()
+	if !C.tcfdbputkeep2(s.db.c_db,
+		bytesPtr(key), C.int(len(key)),
+		bytesPtr(value), C.int(len(value))) {
+		if s.db.LastECode() == TCEKEEP {
+			return
+		}
+		err = s.db.LastError()
+	}
+	return
+}
+
+func (s *FDBStore) PutCat(key []byte, value []byte) (err error) {
+	if err = s.db.state.enter(); err != nil {
+		return
+	}
+	defer s.db.state.exit()
+	if !C.tcfdbputcat2(s.db.c_db,
+		bytesPtr(key), C.int(len(key)),
+		bytesPtr(value), C.int(len(value))) {
+		err = s.db.LastError()
+	}
+	return
+}
+
+func (s *FDBStore) Remove(key []byte) (err error) {
+	if err = s.db.state.enter(); err != nil {
+		return
+	}
+	defer s.db.state.exit()
+	return s.remove(key)
+}
+
+func (s *FDBStore) remove(key []byte) (err error) {
+	if !C.tcfdbout2(s.db.c_db,
+		bytesPtr(key), C.int(len(key))) {
+		err = s.db.LastError()
+	}
+	return
+}
+
+func (s *FDBStore) Get(key []byte) (out []byte, err error) {
+	if err = s.db.state.enter(); err != nil {
+		return
+	}
+	defer s.db.state.exit()
+	return s.get(key)
+}
+
+func (s *FDBStore) get(key []byte) (out []byte, err error) {
+	return s.db.get2(key)
+}
+
+func (s *FDBStore) Size(key []byte) (out int, err error) {
+	if err = s.db.state.enter(); err != nil {
+		return
+	}
+	defer s.db.state.exit()
+	res := C.tcfdbvsiz2(s.db.c_db, bytesPtr(key), C.int(len(key)))
+	if res < 0 {
+		err = s.db.LastError()
+	} else {
+		out = int(res)
+	}
+	return
+}
+
+func (s *FDBStore) Sync() error {
+	return s.db.Sync()
+}
+
+func (s *FDBStore) Iter() *Iterator {
+	return s.db.Iter()
+}
+
+func (s *FDBStore) Keys() iter.Seq[[]byte] {
+	return s.db.Keys()
+}
+
+func (s *FDBStore) Records() iter.Seq2[[]byte, []byte] {
+	return s.db.Records()
+}
+
+func (s *FDBStore) All() iter.Seq2[[]byte, []byte] {
+	return s.db.All()
+}
@@ -1,8 +1,11 @@
 package tokyocabinet
 
 import "bytes"
+import "context"
+import "errors"
 import "io/ioutil"
 import "os"
+import "path/filepath"
 import "testing"
 
 func fdb_assertOpen(t *testing.T, filename string, flags int) FDB {
@@ -122,7 +125,7 @@ func fdb_assertIterKeySet(t *testing.T, db FDB, expectedKeys []int64) {
 	}
 	for i := 0; i < len(expectedKeys); i++ {
 		if !seenKeys[expectedKeys[i]] {
-			t.Fatalf("Did not see expected key %s during iteration", expectedKeys[i])
+			t.Fatalf("Did not see expected key %d during iteration", expectedKeys[i])
 		}
 	}
 }
@@ -200,3 +203,217 @@ func TestFDBTransactions(t *testing.T) {
 	fdb_assertCommitTxn(t, db)
 	fdb_assertGetValue(t, db, 2, "set-inside-txn")
 }
+
+func fdb_assertIDs(t *testing.T, ids []int64, err error, expected []int64) {
+	if err != nil {
+		t.Fatalf("Unable to retrieve range: %s", err)
+	}
+	if len(ids) != len(expected) {
+		t.Fatalf("Expected %d IDs in range, found %d", len(expected), len(ids))
+	}
+	for i := 0; i < len(expected); i++ {
+		if ids[i] != expected[i] {
+			t.Fatalf("Expected ID %d at position %d, got %d", expected[i], i, ids[i])
+		}
+	}
+}
+
+func TestFDBTune(t *testing.T) {
+	db := *NewFDB()
+	if err := db.Tune(4, 1<<20); err != nil {
+		t.Fatalf("Unable to tune database: %s", err)
+	}
+	tf, err := ioutil.TempFile("", "tctest")
+	if err != nil {
+		t.Fatalf("Unable to create temporary file: %s", err)
+	}
+	err = db.Open(tf.Name(), FDBOWRITER|FDBOCREAT|FDBOTRUNC)
+	os.Remove(tf.Name())
+	if err != nil {
+		t.Fatalf("Unable to open %s: %s", tf.Name(), err)
+	}
+	defer db.Del()
+	defer fdb_assertClose(t, db)
+
+	fdb_assertPut(t, db, 1, "abcd")
+	if err := db.Put(2, []byte("abcdef")); err != nil {
+		t.Fatalf("Unable to assign oversized value: %s", err)
+	}
+	fdb_assertGetValue(t, db, 2, "abcd")
+}
+
+func TestFDBRange(t *testing.T) {
+	db := fdb_assertOpen(t, "testrange.fdb", FDBOWRITER|FDBOCREAT|FDBOTRUNC)
+	defer fdb_assertClose(t, db)
+
+	fdb_assertPut(t, db, 3, "three")
+	fdb_assertPut(t, db, 5, "five")
+	fdb_assertPut(t, db, FDBIDNEXT, "six")
+	fdb_assertPut(t, db, FDBIDMIN, "three again")
+	fdb_assertGetValue(t, db, 6, "six")
+	fdb_assertGetValue(t, db, 3, "three again")
+
+	ids, err := db.Range(1, 5, -1)
+	fdb_assertIDs(t, ids, err, []int64{3, 5})
+	ids, err = db.Range(1, 10, 2)
+	fdb_assertIDs(t, ids, err, []int64{3, 5})
+	ids, err = db.RangeString("[min,max]", -1)
+	fdb_assertIDs(t, ids, err, []int64{3, 5, 6})
+	ids, err = db.RangeString("[4,6]", -1)
+	fdb_assertIDs(t, ids, err, []int64{5, 6})
+}
+
+func TestFDBMaintenance(t *testing.T) {
+	// Optimize replaces the file, so it must stay on disk for the whole test
+	filename := filepath.Join(t.TempDir(), "testmaint.fdb")
+	var db FDB = *NewFDB()
+	if err := db.Open(filename, FDBOWRITER|FDBOCREAT|FDBOTRUNC); err != nil {
+		t.Fatalf("Unable to open %s: %s", filename, err)
+	}
+	defer fdb_assertClose(t, db)
+
+	fdb_assertPut(t, db, 1, "hello")
+	fdb_assertPut(t, db, 2, "goodbye")
+	if db.Path() == "" {
+		t.Fatalf("Expected an open database to report its path")
+	}
+	if n := db.RecordCount(); n != 2 {
+		t.Fatalf("Expected 2 records, found %d", n)
+	}
+	if db.FileSize() == 0 {
+		t.Fatalf("Expected a non-zero file size")
+	}
+
+	if err := db.Optimize(0, 0); err != nil {
+		t.Fatalf("Unable to optimize database: %s", err)
+	}
+	if n := db.RecordCount(); n != 2 {
+		t.Fatalf("Expected 2 records after optimizing, found %d", n)
+	}
+
+	tf, err := ioutil.TempFile("", "tctest")
+	if err != nil {
+		t.Fatalf("Unable to create temporary file: %s", err)
+	}
+	if err := db.Copy(tf.Name()); err != nil {
+		t.Fatalf("Unable to copy database: %s", err)
+	}
+	copied := fdb_assertOpen(t, tf.Name(), FDBOREADER)
+	fdb_assertGetValue(t, copied, 1, "hello")
+	fdb_assertClose(t, copied)
+
+	if err := db.Vanish(); err != nil {
+		t.Fatalf("Unable to vanish database: %s", err)
+	}
+	if n := db.RecordCount(); n != 0 {
+		t.Fatalf("Expected no records after vanishing, found %d", n)
+	}
+}
+
+func TestFDBIterCancel(t *testing.T) {
+	db := fdb_assertOpen(t, "testitercancel.fdb", FDBOWRITER|FDBOCREAT|FDBOTRUNC)
+	defer fdb_assertClose(t, db)
+
+	fdb_assertPut(t, db, 1, "a")
+	fdb_assertPut(t, db, 2, "b")
+	fdb_assertPut(t, db, 3, "c")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result_chan, err_chan := db.IterKeysContext(ctx)
+	if _, ok := <-result_chan; !ok {
+		t.Fatalf("Expected at least one key before cancelling")
+	}
+	cancel()
+	for range result_chan {
+	}
+	if err := <-err_chan; err != nil && err != context.Canceled {
+		t.Fatalf("Unexpected error after cancelling iteration: %s", err)
+	}
+	if _, ok := <-err_chan; ok {
+		t.Fatalf("Expected error channel to be closed")
+	}
+}
+
+func TestFDBRangeFunc(t *testing.T) {
+	db := fdb_assertOpen(t, "testrangefunc.fdb", FDBOWRITER|FDBOCREAT|FDBOTRUNC)
+	defer fdb_assertClose(t, db)
+
+	fdb_assertPut(t, db, 1, "world")
+	fdb_assertPut(t, db, 2, "moon")
+
+	// keys come back as decimal strings, as with FDBStore
+	seen := make(map[string]string)
+	it := db.Iter()
+	for key, value := range it.All() {
+		seen[string(key)] = string(value)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("Error while iterating over records: %s", err)
+	}
+	if len(seen) != 2 || seen["1"] != "world" || seen["2"] != "moon" {
+		t.Fatalf("Unexpected records during iteration: %v", seen)
+	}
+
+	count := 0
+	for range db.Keys() {
+		count++
+		break
+	}
+	if count != 1 {
+		t.Fatalf("Expected to stop after 1 key, saw %d", count)
+	}
+}
+
+func TestFDBEmpty(t *testing.T) {
+	db := fdb_assertOpen(t, "testempty.fdb", FDBOWRITER|FDBOCREAT|FDBOTRUNC)
+	defer fdb_assertClose(t, db)
+	fdb_assertPut(t, db, 1, "")
+	fdb_assertGetValue(t, db, 1, "")
+	if size := fdb_assertGetSize(t, db, 1); size != 0 {
+		t.Fatalf("Expected an empty value, got size %d", size)
+	}
+	fdb_assertPutCat(t, db, 1, "")
+	fdb_assertPutCat(t, db, 1, "bar")
+	fdb_assertGetValue(t, db, 1, "bar")
+	fdb_assertPutKeep(t, db, 2, "")
+	if err := db.Put(3, nil); err != nil {
+		t.Fatalf("Unable to put a nil value: %s", err)
+	}
+	fdb_assertGetValue(t, db, 3, "")
+	fdb_assertIterKeySet(t, db, []int64{1, 2, 3})
+}
+
+func TestFDBGetInto(t *testing.T) {
+	db := fdb_assertOpen(t, "testgetinto.fdb", FDBOWRITER|FDBOCREAT|FDBOTRUNC)
+	defer fdb_assertClose(t, db)
+	fdb_assertPut(t, db, 1, "foobar")
+	fdb_assertPut(t, db, 2, "")
+
+	buf := make([]byte, 16)
+	n, err := db.GetInto(1, buf)
+	if err != nil || string(buf[:n]) != "foobar" {
+		t.Fatalf("Expected foobar, got %q (%v)", buf[:n], err)
+	}
+	n, err = db.GetInto(1, buf[:3])
+	if err != nil || string(buf[:n]) != "foo" {
+		t.Fatalf("Expected a truncated value, got %q (%v)", buf[:n], err)
+	}
+	n, err = db.GetInto(2, buf)
+	if err != nil || n != 0 {
+		t.Fatalf("Expected an empty value, got %d bytes (%v)", n, err)
+	}
+	if _, err = db.GetInto(3, buf); !errors.Is(err, ErrNoRecord) {
+		t.Fatalf("Expected ErrNoRecord, got %v", err)
+	}
+
+	var seen string
+	err = db.GetFunc(1, func(value []byte) { seen = string(value) })
+	if err != nil || seen != "foobar" {
+		t.Fatalf("Expected foobar, got %q (%v)", seen, err)
+	}
+	called := false
+	err = db.GetFunc(3, func(value []byte) { called = true })
+	if called || !errors.Is(err, ErrNoRecord) {
+		t.Fatalf("Expected ErrNoRecord without a call, got %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+package tokyocabinet
+
+// #cgo pkg-config: tokyocabinet
+// #include <tchdb.h>
+// #include <tcbdb.h>
+// #include <tcfdb.h>
+// #include <tcadb.h>
+// extern bool goForEach(void *kbuf, int ksiz, void *vbuf, int vsiz, void *op);
+import "C"
+
+import (
+	"runtime/cgo"
+	"unsafe"
+)
+
+/*
+ * called once per record until it returns false. key and value are borrowed
+ * from the library and only valid for the duration of the call; copy them
+ * to keep them. the database is held for the whole scan, so the function
+ * must not use it.
+ */
+type ForEachFunc func(key []byte, value []byte) bool
+
+func (db *HDB) ForEach(fn ForEachFunc) (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	if !forEach(fn, func(iter C.TCITER, op unsafe.Pointer) C.bool {
+		return C.tchdbforeach(db.c_db, iter, op)
+	}) {
+		err = db.LastError()
+	}
+	return
+}
+
+/* in key order, once per duplicate value */
+func (db *BDB) ForEach(fn ForEachFunc) (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	if !forEach(fn, func(iter C.TCITER, op unsafe.Pointer) C.bool {
+		return C.tcbdbforeach(db.c_db, iter, op)
+	}) {
+		err = db.LastError()
+	}
+	return
+}
+
+/* keys are decimal strings, as with FDBStore */
+func (db *FDB) ForEach(fn ForEachFunc) (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	if !forEach(fn, func(iter C.TCITER, op unsafe.Pointer) C.bool {
+		return C.tcfdbforeach(db.c_db, iter, op)
+	}) {
+		err = db.LastError()
+	}
+	return
+}
+
+func (db *ADB) ForEach(fn ForEachFunc) (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	if !forEach(fn, func(iter C.TCITER, op unsafe.Pointer) C.bool {
+		return C.tcadbforeach(db.c_db, iter, op)
+	}) {
+		err = db.LastError()
+	}
+	return
+}
+
+type forEachState struct {
+	fn    ForEachFunc
+	relay panicRelay
+}
+
+/*
+ * runs scan with the callback and op for fn. a panic in fn stops the scan
+ * and is raised again once the library has returned.
+ */
+func forEach(fn ForEachFunc, scan func(iter C.TCITER, op unsafe.Pointer) C.bool) bool {
+	state := &forEachState{fn: fn}
+	handle := cgo.NewHandle(state)
+	defer handle.Delete()
+	ok := scan((C.TCITER)(C.goForEach), unsafe.Pointer(&handle))
+	state.relay.raise()
+	return bool(ok)
+}
+
+//export goForEach
+func goForEach(kbuf unsafe.Pointer, ksiz C.int, vbuf unsafe.Pointer, vsiz C.int, op unsafe.Pointer) (more C.bool) {
+	state := (*(*cgo.Handle)(op)).Value().(*forEachState)
+	defer state.relay.catch(func() { more = false })
+	key := unsafe.Slice((*byte)(kbuf), int(ksiz))
+	value := unsafe.Slice((*byte)(vbuf), int(vsiz))
+	return C.bool(state.fn(key, value))
+}
@@ -0,0 +1,112 @@
+package tokyocabinet
+
+import "testing"
+
+func foreach_assertRecords(t *testing.T, seen map[string]string, expected map[string]string) {
+	if len(seen) != len(expected) {
+		t.Fatalf("Expected %d records, saw %d: %v", len(expected), len(seen), seen)
+	}
+	for key, value := range expected {
+		if seen[key] != value {
+			t.Fatalf("Record %s came back incorrect (expected: %s; got: %s)", key, value, seen[key])
+		}
+	}
+}
+
+func TestHDBForEach(t *testing.T) {
+	db := hdb_assertOpen(t, "testforeach.hdb", HDBOWRITER|HDBOCREAT|HDBOTRUNC)
+	defer hdb_assertClose(t, db)
+	hdb_assertPut(t, db, "a", "1")
+	hdb_assertPut(t, db, "b", "2")
+	hdb_assertPut(t, db, "c", "")
+
+	seen := make(map[string]string)
+	err := db.ForEach(func(key []byte, value []byte) bool {
+		seen[string(key)] = string(value)
+		return true
+	})
+	if err != nil {
+		t.Fatalf("Unable to scan records: %s", err)
+	}
+	foreach_assertRecords(t, seen, map[string]string{"a": "1", "b": "2", "c": ""})
+
+	calls := 0
+	err = db.ForEach(func(key []byte, value []byte) bool {
+		calls++
+		return false
+	})
+	if err != nil || calls != 1 {
+		t.Fatalf("Expected the scan to stop after one record, got %d calls (%v)", calls, err)
+	}
+
+	func() {
+		defer func() {
+			if recover() != "failure" {
+				t.Fatalf("Expected the panic to propagate")
+			}
+		}()
+		db.ForEach(func(key []byte, value []byte) bool {
+			panic("failure")
+		})
+	}()
+	// the database must not be left locked by the panic
+	hdb_assertPut(t, db, "d", "4")
+}
+
+func TestBDBForEach(t *testing.T) {
+	db := bdb_assertOpen(t, "testforeach.bdb", BDBOWRITER|BDBOCREAT|BDBOTRUNC)
+	defer bdb_assertClose(t, db)
+	bdb_assertPut(t, db, "b", "2")
+	bdb_assertPut(t, db, "a", "1")
+	if err := db.PutDup([]byte("b"), []byte("3")); err != nil {
+		t.Fatalf("Unable to put duplicate: %s", err)
+	}
+
+	var records []string
+	err := db.ForEach(func(key []byte, value []byte) bool {
+		records = append(records, string(key)+"="+string(value))
+		return true
+	})
+	if err != nil {
+		t.Fatalf("Unable to scan records: %s", err)
+	}
+	if len(records) != 3 || records[0] != "a=1" || records[1] != "b=2" || records[2] != "b=3" {
+		t.Fatalf("Expected records in key order, got %v", records)
+	}
+}
+
+func TestFDBForEach(t *testing.T) {
+	db := fdb_assertOpen(t, "testforeach.fdb", FDBOWRITER|FDBOCREAT|FDBOTRUNC)
+	defer fdb_assertClose(t, db)
+	fdb_assertPut(t, db, 1, "one")
+	fdb_assertPut(t, db, 42, "forty-two")
+
+	seen := make(map[string]string)
+	err := db.ForEach(func(key []byte, value []byte) bool {
+		seen[string(key)] = string(value)
+		return true
+	})
+	if err != nil {
+		t.Fatalf("Unable to scan records: %s", err)
+	}
+	foreach_assertRecords(t, seen, map[string]string{"1": "one", "42": "forty-two"})
+}
+
+func TestADBForEach(t *testing.T) {
+	for _, name := range []string{"testforeach.tch", "*"} {
+		db := adb_assertOpen(t, name)
+		adb_assertPut(t, db, "a", "1")
+		adb_assertPut(t, db, "b", "2")
+
+		seen := make(map[string]string)
+		err := db.ForEach(func(key []byte, value []byte) bool {
+			seen[string(key)] = string(value)
+			return true
+		})
+		if err != nil {
+			t.Fatalf("Unable to scan records of %s: %s", name, err)
+		}
+		foreach_assertRecords(t, seen, map[string]string{"a": "1", "b": "2"})
+		adb_assertClose(t, db)
+	}
+}
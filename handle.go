@@ -0,0 +1,224 @@
+package tokyocabinet
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+/* returned by methods called on a handle that is not open or has been deleted */
+var ErrClosed = errors.New("tokyocabinet: database handle is closed")
+
+/* returned by IterKeys while another loop over the same database is running */
+var ErrBusy = errors.New("tokyocabinet: database is already being iterated")
+
+var leakHook atomic.Pointer[func(kind string)]
+
+/*
+ * registers a function to be called with the type name ("HDB", "BDBCursor",
+ * ...) whenever a handle is garbage collected without Del having been called.
+ * the native object is released right after the hook returns. pass nil to
+ * remove the hook.
+ */
+func SetLeakHook(hook func(kind string)) {
+	if hook == nil {
+		leakHook.Store(nil)
+		return
+	}
+	leakHook.Store(&hook)
+}
+
+func reportLeak(kind string) {
+	if hook := leakHook.Load(); hook != nil {
+		(*hook)(kind)
+	}
+}
+
+/*
+ * lifecycle state shared by every copy of a database handle. del releases
+ * the native object, and runs from a cleanup if the handle is leaked; it must
+ * not refer to the handle itself.
+ *
+ * methods hold txn for the duration of a call: shared once the native mutex
+ * is enabled, otherwise exclusively, since the library does no locking of its
+ * own. Update, View, Close and Del always hold it exclusively. iter is held
+ * for the whole of a loop over the records, as every loop shares the one
+ * native iterator.
+ *
+ * with the native mutex the library keeps the last error code per thread, so
+ * the goroutine is locked to its thread while txn is held; the code read
+ * after a failed call then belongs to that call.
+ *
+ * callbacks the library makes during any call, such as a BDB comparator,
+ * relay their panics through callbacks, which are raised again once txn is
+ * released. with the native mutex, that may be in a call running alongside
+ * the one that panicked.
+ */
+type handle struct {
+	open      atomic.Bool
+	deleted   atomic.Bool
+	mutex     atomic.Bool
+	cleanup   runtime.Cleanup
+	txn       sync.RWMutex
+	iter      sync.Mutex
+	callbacks *panicRelay
+	// set while rlock holds txn exclusively
+	exclusive bool
+}
+
+func newHandle(kind string, del func()) *handle {
+	// the relay is kept apart so that callback ops holding it don't keep
+	// the handle from being collected
+	h := &handle{callbacks: &panicRelay{}}
+	h.cleanup = runtime.AddCleanup(h, func(del func()) {
+		reportLeak(kind)
+		del()
+	}, del)
+	return h
+}
+
+/* ErrClosed once the handle has been deleted */
+func (h *handle) live() error {
+	if h == nil || h.deleted.Load() {
+		return ErrClosed
+	}
+	return nil
+}
+
+/* ErrClosed unless the handle is open */
+func (h *handle) ready() error {
+	if err := h.live(); err != nil {
+		return err
+	}
+	if !h.open.Load() {
+		return ErrClosed
+	}
+	return nil
+}
+
+/* as ready, holding txn until exit is called if there is no error */
+func (h *handle) enter() error {
+	return h.enterIf(h.ready)
+}
+
+/* as live, holding txn until exit is called if there is no error */
+func (h *handle) enterLive() error {
+	return h.enterIf(h.live)
+}
+
+func (h *handle) enterIf(check func() error) error {
+	if h == nil {
+		return ErrClosed
+	}
+	h.rlock()
+	if err := check(); err != nil {
+		h.exit()
+		return err
+	}
+	return nil
+}
+
+func (h *handle) rlock() {
+	runtime.LockOSThread()
+	if h.mutex.Load() {
+		h.txn.RLock()
+	} else {
+		h.txn.Lock()
+		h.exclusive = true
+	}
+}
+
+/*
+ * undoes rlock. SetMutex may have changed mutex while rlock waited, so the
+ * mode it took is read back from exclusive instead; only a holder of the
+ * exclusive lock ever writes it.
+ */
+func (h *handle) exit() {
+	if h.exclusive {
+		h.exclusive = false
+		h.txn.Unlock()
+	} else {
+		h.txn.RUnlock()
+	}
+	runtime.UnlockOSThread()
+	h.callbacks.raise()
+}
+
+/* as ready, holding txn exclusively until unlock is called if there is no error */
+func (h *handle) lock() error {
+	return h.lockIf(h.ready)
+}
+
+/* as live, holding txn exclusively until unlock is called if there is no error */
+func (h *handle) lockLive() error {
+	return h.lockIf(h.live)
+}
+
+func (h *handle) lockIf(check func() error) error {
+	if h == nil {
+		return ErrClosed
+	}
+	runtime.LockOSThread()
+	h.txn.Lock()
+	if err := check(); err != nil {
+		h.unlock()
+		return err
+	}
+	return nil
+}
+
+func (h *handle) unlock() {
+	h.txn.Unlock()
+	runtime.UnlockOSThread()
+	h.callbacks.raise()
+}
+
+/* as ready, holding iter until endIter is called if there is no error */
+func (h *handle) beginIter() error {
+	if h == nil {
+		return ErrClosed
+	}
+	h.iter.Lock()
+	if err := h.ready(); err != nil {
+		h.iter.Unlock()
+		return err
+	}
+	return nil
+}
+
+/* as beginIter, failing with ErrBusy rather than waiting for another loop */
+func (h *handle) tryBeginIter() error {
+	if h == nil {
+		return ErrClosed
+	}
+	if !h.iter.TryLock() {
+		return ErrBusy
+	}
+	if err := h.ready(); err != nil {
+		h.iter.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (h *handle) endIter() {
+	h.iter.Unlock()
+}
+
+/*
+ * true for the first caller only, who must then release the native object.
+ * waits for calls in progress to finish.
+ */
+func (h *handle) release() bool {
+	if h == nil {
+		return false
+	}
+	h.txn.Lock()
+	defer h.txn.Unlock()
+	if !h.deleted.CompareAndSwap(false, true) {
+		return false
+	}
+	h.cleanup.Stop()
+	return true
+}
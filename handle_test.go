@@ -0,0 +1,112 @@
+package tokyocabinet
+
+import "errors"
+import "runtime"
+import "sync"
+import "testing"
+import "time"
+
+func TestHandleCloseDel(t *testing.T) {
+	db := hdb_assertOpen(t, "testhandle.hdb", HDBOWRITER|HDBOCREAT|HDBOTRUNC)
+	hdb_assertPut(t, db, "foo", "bar")
+	hdb_assertClose(t, db)
+	hdb_assertClose(t, db)
+	if _, err := db.Get([]byte("foo")); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Expected ErrClosed after Close, got %v", err)
+	}
+	db.Del()
+	db.Del()
+	if err := db.Put([]byte("foo"), []byte("bar")); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Expected ErrClosed after Del, got %v", err)
+	}
+	if err := db.Open("testhandle.hdb", HDBOWRITER); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Expected ErrClosed opening a deleted handle, got %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Expected Close of a deleted handle to do nothing, got %v", err)
+	}
+	if db.LastECode() != TCINVALID {
+		t.Fatalf("Expected TCEINVALID from a deleted handle, got %d", db.LastECode())
+	}
+}
+
+func TestHandleZeroValue(t *testing.T) {
+	var db HDB
+	if err := db.Put([]byte("foo"), []byte("bar")); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Expected ErrClosed from a zero HDB, got %v", err)
+	}
+	it := db.Iter()
+	for range it.Keys() {
+		t.Fatalf("Expected no keys from a zero HDB")
+	}
+	if !errors.Is(it.Err(), ErrClosed) {
+		t.Fatalf("Expected ErrClosed from iterating a zero HDB, got %v", it.Err())
+	}
+	db.Del()
+}
+
+func TestHandleCursorAfterDel(t *testing.T) {
+	db := bdb_assertOpen(t, "testhandle.bdb", BDBOWRITER|BDBOCREAT|BDBOTRUNC)
+	bdb_assertPut(t, db, "foo", "bar")
+	cur := NewBDBCursor(&db)
+	if err := cur.First(); err != nil {
+		t.Fatalf("Unable to move cursor: %s", err)
+	}
+	db.Del()
+	if _, err := cur.Key(); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Expected ErrClosed from a cursor of a deleted BDB, got %v", err)
+	}
+	cur.Del()
+	cur.Del()
+	if NewBDBCursor(&db).First() == nil {
+		t.Fatalf("Expected a cursor of a deleted BDB to fail")
+	}
+}
+
+func TestHandleLeak(t *testing.T) {
+	leaked := make(chan string, 16)
+	SetLeakHook(func(kind string) {
+		select {
+		case leaked <- kind:
+		default:
+		}
+	})
+	defer SetLeakHook(nil)
+
+	NewHDB()
+	deadline := time.After(5 * time.Second)
+	for {
+		runtime.GC()
+		select {
+		case kind := <-leaked:
+			// handles left behind by other tests may be reported too
+			if kind == "HDB" {
+				return
+			}
+		case <-deadline:
+			t.Fatalf("Expected the leak hook to fire")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+/* calls waiting on the handle while SetMutex runs must still release it */
+func TestHandleSetMutexWhileWaiting(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		db := NewHDB()
+		var wg sync.WaitGroup
+		for g := 0; g < 4; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				// not open yet, so this only takes and releases the handle
+				db.Put([]byte("key"), []byte("value"))
+			}()
+		}
+		if err := db.SetMutex(); err != nil {
+			t.Fatalf("Unable to enable the mutex: %s", err)
+		}
+		wg.Wait()
+		db.Del()
+	}
+}
@@ -5,13 +5,17 @@ package tokyocabinet
 // #include <tchdb.h>
 import "C"
 
-import "unsafe"
+import (
+	"context"
+	"iter"
+	"unsafe"
+)
 
 const HDBFOPEN int = C.HDBFOPEN
 const HDBFFATAL int = C.HDBFFATAL
 
 const HDBTLARGE int = C.HDBTLARGE
-const HDBTDEFLATE int = C.HDBTLARGE
+const HDBTDEFLATE int = C.HDBTDEFLATE
 const HDBTBZIP int = C.HDBTBZIP
 const HDBTTCBS int = C.HDBTTCBS
 const HDBTEXCODEC int = C.HDBTEXCODEC
@@ -28,44 +32,88 @@ func ECodeNameHDB(ecode int) string {
 }
 
 type HDB struct {
-	c_db *C.TCHDB
+	c_db  *C.TCHDB
+	state *handle
+	codec *callbackOp
 }
 
 func NewHDB() *HDB {
 	c_db := C.tchdbnew()
-	return &HDB{c_db}
+	codec := &callbackOp{}
+	state := newHandle("HDB", func() {
+		C.tchdbdel(c_db)
+		codec.release()
+	})
+	return &HDB{c_db: c_db, state: state, codec: codec}
 }
 
+/* safe to call more than once; every other method fails afterwards */
 func (db *HDB) Del() {
-	C.tchdbdel(db.c_db)
+	if db.state.release() {
+		C.tchdbdel(db.c_db)
+		db.c_db = nil
+		db.codec.release()
+	}
 }
 
+/* TCEINVALID once the handle has been deleted */
 func (db *HDB) LastECode() int {
+	if db.state.live() != nil {
+		return TCINVALID
+	}
 	return int(C.tchdbecode(db.c_db))
 }
 
 func (db *HDB) LastError() error {
+	if err := db.state.live(); err != nil {
+		return err
+	}
 	code := db.LastECode()
 	return NewTokyoCabinetError(code, ECodeNameHDB(code))
 }
 
 func (db *HDB) Open(path string, omode int) (err error) {
+	if err = db.state.lockLive(); err != nil {
+		return
+	}
+	defer db.state.unlock()
 	c_path := C.CString(path)
 	defer C.free(unsafe.Pointer(c_path))
 	if !C.tchdbopen(db.c_db, c_path, C.int(omode)) {
 		err = db.LastError()
+	} else if C.tchdbopts(db.c_db)&C.HDBTEXCODEC != 0 && !db.codec.isSet() {
+		// the library would call through a null codec function
+		C.tchdbclose(db.c_db)
+		err = NewTokyoCabinetError(TCINVALID, ECodeNameHDB(TCINVALID))
+	} else {
+		db.state.open.Store(true)
 	}
 	return
 }
 
+/* closing a handle that is not open does nothing */
 func (db *HDB) Close() (err error) {
+	if db.state.lock() != nil {
+		return
+	}
+	defer db.state.unlock()
 	if !C.tchdbclose(db.c_db) {
 		err = db.LastError()
+	} else {
+		db.state.open.Store(false)
 	}
 	return
 }
 
 func (db *HDB) BeginTxn() (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	return db.begin()
+}
+
+func (db *HDB) begin() (err error) {
 	if !C.tchdbtranbegin(db.c_db) {
 		err = db.LastError()
 	}
@@ -73,6 +121,14 @@ func (db *HDB) BeginTxn() (err error) {
 }
 
 func (db *HDB) CommitTxn() (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	return db.commit()
+}
+
+func (db *HDB) commit() (err error) {
 	if !C.tchdbtrancommit(db.c_db) {
 		err = db.LastError()
 	}
@@ -80,25 +136,58 @@ func (db *HDB) CommitTxn() (err error) {
 }
 
 func (db *HDB) AbortTxn() (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	return db.abort()
+}
+
+func (db *HDB) abort() (err error) {
 	if !C.tchdbtranabort(db.c_db) {
 		err = db.LastError()
 	}
 	return
 }
 
+/*
+ * runs fn in a transaction holding the database exclusively. the transaction
+ * is committed if fn returns nil, and aborted if it returns an error or panics.
+ */
+func (db *HDB) Update(fn func(tx *Tx) error) error {
+	return runTx(db.state, db, true, fn)
+}
+
+/* as Update, for reads only; nothing is committed or aborted */
+func (db *HDB) View(fn func(tx *Tx) error) error {
+	return runTx(db.state, db, false, fn)
+}
+
 func (db *HDB) Put(key []byte, value []byte) (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	return db.put(key, value)
+}
+
+func (db *HDB) put(key []byte, value []byte) (err error) {
 	if !C.tchdbput(db.c_db,
-		unsafe.Pointer(&key[0]), C.int(len(key)),
-		unsafe.Pointer(&value[0]), C.int(len(value))) {
+		bytesPtr(key), C.int(len(key)),
+		bytesPtr(value), C.int(len(value))) {
 		err = db.LastError()
 	}
 	return
 }
 
 func (db *HDB) PutKeep(key []byte, value []byte) (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
 	if !C.tchdbputkeep(db.c_db,
-		unsafe.Pointer(&key[0]), C.int(len(key)),
-		unsafe.Pointer(&value[0]), C.int(len(value))) {
+		bytesPtr(key), C.int(len(key)),
+		bytesPtr(value), C.int(len(value))) {
 		if db.LastECode() == TCEKEEP {
 			return
 		}
@@ -108,17 +197,25 @@ func (db *HDB) PutKeep(key []byte, value []byte) (err error) {
 }
 
 func (db *HDB) PutCat(key []byte, value []byte) (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
 	if !C.tchdbputcat(db.c_db,
-		unsafe.Pointer(&key[0]), C.int(len(key)),
-		unsafe.Pointer(&value[0]), C.int(len(value))) {
+		bytesPtr(key), C.int(len(key)),
+		bytesPtr(value), C.int(len(value))) {
 		err = db.LastError()
 	}
 	return
 }
 
 func (db *HDB) AddInt(key []byte, value int) (newvalue int, err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
 	res := C.tchdbaddint(db.c_db,
-		unsafe.Pointer(&key[0]), C.int(len(key)),
+		bytesPtr(key), C.int(len(key)),
 		C.int(value))
 	if res == C.INT_MIN {
 		err = db.LastError()
@@ -128,8 +225,12 @@ func (db *HDB) AddInt(key []byte, value int) (newvalue int, err error) {
 }
 
 func (db *HDB) AddDouble(key []byte, value float64) (newvalue float64, err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
 	res := C.tchdbadddouble(db.c_db,
-		unsafe.Pointer(&key[0]), C.int(len(key)),
+		bytesPtr(key), C.int(len(key)),
 		C.double(value))
 	if isnan(res) {
 		err = db.LastError()
@@ -139,17 +240,33 @@ func (db *HDB) AddDouble(key []byte, value float64) (newvalue float64, err error
 }
 
 func (db *HDB) Remove(key []byte) (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	return db.remove(key)
+}
+
+func (db *HDB) remove(key []byte) (err error) {
 	if !C.tchdbout(db.c_db,
-		unsafe.Pointer(&key[0]), C.int(len(key))) {
+		bytesPtr(key), C.int(len(key))) {
 		err = db.LastError()
 	}
 	return
 }
 
 func (db *HDB) Get(key []byte) (out []byte, err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	return db.get(key)
+}
+
+func (db *HDB) get(key []byte) (out []byte, err error) {
 	var size C.int
 	rec := C.tchdbget(db.c_db,
-		unsafe.Pointer(&key[0]), C.int(len(key)),
+		bytesPtr(key), C.int(len(key)),
 		&size)
 	if rec != nil {
 		defer C.free(unsafe.Pointer(rec))
@@ -160,8 +277,56 @@ func (db *HDB) Get(key []byte) (out []byte, err error) {
 	return
 }
 
+/*
+ * copies the value into buf without allocating, returning the number of bytes
+ * written. a value longer than buf is truncated; Size reports its full length.
+ */
+func (db *HDB) GetInto(key []byte, buf []byte) (n int, err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	res := C.tchdbget3(db.c_db,
+		bytesPtr(key), C.int(len(key)),
+		bytesPtr(buf), C.int(len(buf)))
+	if res < 0 {
+		err = db.LastError()
+	} else {
+		n = int(res)
+	}
+	return
+}
+
+/*
+ * passes the value to fn without copying it into Go memory. the slice is
+ * borrowed from the library and only valid for the duration of the call.
+ */
+func (db *HDB) GetFunc(key []byte, fn func(value []byte)) (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	var size C.int
+	rec := C.tchdbget(db.c_db,
+		bytesPtr(key), C.int(len(key)),
+		&size)
+	if rec == nil {
+		err = db.LastError()
+	}
+	db.state.exit()
+	if rec == nil {
+		return
+	}
+	defer C.free(rec)
+	fn(unsafe.Slice((*byte)(rec), int(size)))
+	return
+}
+
 func (db *HDB) Size(key []byte) (out int, err error) {
-	res := C.tchdbvsiz(db.c_db, unsafe.Pointer(&key[0]), C.int(len(key)))
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	res := C.tchdbvsiz(db.c_db, bytesPtr(key), C.int(len(key)))
 	if res < 0 {
 		err = db.LastError()
 	} else {
@@ -170,12 +335,44 @@ func (db *HDB) Size(key []byte) (out int, err error) {
 	return
 }
 
-/* note that only one iterator can be active at a time for a given database */
+/* keys beginning with prefix; negative max for infinite */
+func (db *HDB) FwmKeys(prefix []byte, max int) (keys [][]byte) {
+	if db.state.enter() != nil {
+		return
+	}
+	defer db.state.exit()
+	resList := C.tchdbfwmkeys(db.c_db,
+		bytesPtr(prefix), C.int(len(prefix)),
+		C.int(max))
+	defer C.tclistdel(resList)
+	keys = goList(resList)
+	return
+}
+
+/*
+ * the caller must read c until it is closed, as the database cannot be
+ * iterated again until then; use IterKeysContext to be able to stop early.
+ * fails with ErrBusy on e if another loop over the database is running.
+ */
 func (db *HDB) IterKeys() (c chan []byte, e chan error) {
+	return db.IterKeysContext(context.Background())
+}
+
+/*
+ * like IterKeys, but stops iterating once ctx is done, reporting ctx.Err()
+ * on e. e is buffered so that a final error never blocks the iterator.
+ */
+func (db *HDB) IterKeysContext(ctx context.Context) (c chan []byte, e chan error) {
 	c = make(chan []byte)
-	e = make(chan error)
-	if !C.tchdbiterinit(db.c_db) {
-		e <- db.LastError()
+	e = make(chan error, 1)
+	err := db.state.tryBeginIter()
+	if err == nil {
+		if err = db.iterInit(); err != nil {
+			db.state.endIter()
+		}
+	}
+	if err != nil {
+		e <- err
 		close(c)
 		close(e)
 		return
@@ -183,24 +380,205 @@ func (db *HDB) IterKeys() (c chan []byte, e chan error) {
 	go func() {
 		defer close(c)
 		defer close(e)
+		defer db.state.endIter()
 		for {
-			var size C.int
-			rec := C.tchdbiternext(db.c_db, &size)
-			if rec != nil {
-				c <- C.GoBytes(rec, size)
-				C.free(rec)
-				continue
+			key, ok, err := db.iterNext()
+			if !ok {
+				if err != nil {
+					e <- err
+				}
+				return
 			}
-			if db.LastECode() != TCENOREC {
-				e <- db.LastError()
+			select {
+			case c <- key:
+			case <-ctx.Done():
+				e <- ctx.Err()
+				return
 			}
-			break
 		}
 	}()
 	return
 }
 
+/* a loop over the records that reports the error, if any, that ended it */
+func (db *HDB) Iter() *Iterator {
+	return &Iterator{keys: db.scanKeys, records: db.scanRecords}
+}
+
+/*
+ * range-over-func iteration over every key. an error ends the loop as the
+ * last record does; loop over Iter to tell the two apart.
+ */
+func (db *HDB) Keys() iter.Seq[[]byte] {
+	return db.Iter().Keys()
+}
+
+/* as Keys, yielding each value along with its key */
+func (db *HDB) Records() iter.Seq2[[]byte, []byte] {
+	return db.Iter().Records()
+}
+
+func (db *HDB) All() iter.Seq2[[]byte, []byte] {
+	return db.Iter().All()
+}
+
+func (db *HDB) scanKeys(yield func([]byte) bool) (err error) {
+	if err = db.state.beginIter(); err != nil {
+		return
+	}
+	defer db.state.endIter()
+	if err = db.iterInit(); err != nil {
+		return
+	}
+	for {
+		key, ok, err := db.iterNext()
+		if !ok {
+			return err
+		}
+		if !yield(key) {
+			return nil
+		}
+	}
+}
+
+func (db *HDB) iterInit() (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	if !C.tchdbiterinit(db.c_db) {
+		err = db.LastError()
+	}
+	return
+}
+
+/* ok is false at the end of the records, or on error */
+func (db *HDB) iterNext() (key []byte, ok bool, err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	var size C.int
+	rec := C.tchdbiternext(db.c_db, &size)
+	if rec == nil {
+		if db.LastECode() != TCENOREC {
+			err = db.LastError()
+		}
+		return
+	}
+	defer C.free(rec)
+	return C.GoBytes(rec, size), true, nil
+}
+
+func (db *HDB) scanRecords(yield func([]byte, []byte) bool) (err error) {
+	if err = db.state.beginIter(); err != nil {
+		return
+	}
+	defer db.state.endIter()
+	if err = db.iterInit(); err != nil {
+		return
+	}
+	c_key := C.tcxstrnew()
+	defer C.tcxstrdel(c_key)
+	c_value := C.tcxstrnew()
+	defer C.tcxstrdel(c_value)
+	for {
+		ok, err := db.iterNext3(c_key, c_value)
+		if !ok {
+			return err
+		}
+		key := C.GoBytes(C.tcxstrptr(c_key), C.tcxstrsize(c_key))
+		value := C.GoBytes(C.tcxstrptr(c_value), C.tcxstrsize(c_value))
+		if !yield(key, value) {
+			return nil
+		}
+	}
+}
+
+/* as iterNext, reading the record into c_key and c_value */
+func (db *HDB) iterNext3(c_key *C.TCXSTR, c_value *C.TCXSTR) (ok bool, err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	if ok = bool(C.tchdbiternext3(db.c_db, c_key, c_value)); !ok {
+		if db.LastECode() != TCENOREC {
+			err = db.LastError()
+		}
+	}
+	return
+}
+
+/* removes every record */
+func (db *HDB) Vanish() (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	if !C.tchdbvanish(db.c_db) {
+		err = db.LastError()
+	}
+	return
+}
+
+/* copies the database file to path; safe to call while the database is in use */
+func (db *HDB) Copy(path string) (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	c_path := C.CString(path)
+	defer C.free(unsafe.Pointer(c_path))
+	if !C.tchdbcopy(db.c_db, c_path) {
+		err = db.LastError()
+	}
+	return
+}
+
+/* the HDBT* flags the database was tuned or created with */
+func (db *HDB) Options() uint8 {
+	if db.state.enterLive() != nil {
+		return 0
+	}
+	defer db.state.exit()
+	return uint8(C.tchdbopts(db.c_db))
+}
+
+/* the compression selected by Options */
+func (db *HDB) Compression() Compression {
+	return compressionOf(db.Options(), HDBTDEFLATE, HDBTBZIP, HDBTTCBS, HDBTEXCODEC)
+}
+
+/* empty if the database is not open */
+func (db *HDB) Path() string {
+	if db.state.enterLive() != nil {
+		return ""
+	}
+	defer db.state.exit()
+	return C.GoString(C.tchdbpath(db.c_db))
+}
+
+func (db *HDB) RecordCount() uint64 {
+	if db.state.enter() != nil {
+		return 0
+	}
+	defer db.state.exit()
+	return uint64(C.tchdbrnum(db.c_db))
+}
+
+func (db *HDB) FileSize() uint64 {
+	if db.state.enter() != nil {
+		return 0
+	}
+	defer db.state.exit()
+	return uint64(C.tchdbfsiz(db.c_db))
+}
+
 func (db *HDB) Sync() (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
 	if !C.tchdbsync(db.c_db) {
 		err = db.LastError()
 	}
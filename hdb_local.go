@@ -3,9 +3,31 @@ package tokyocabinet
 // #cgo pkg-config: tokyocabinet
 // #include <tchdb.h>
 import "C"
-import "unsafe"
 
+/*
+ * enables the library's own locking so that calls from several goroutines
+ * can run in parallel; without it they take turns. must be called before
+ * Open.
+ */
+func (db *HDB) SetMutex() (err error) {
+	if err = db.state.lockLive(); err != nil {
+		return
+	}
+	defer db.state.unlock()
+	if !C.tchdbsetmutex(db.c_db) {
+		err = db.LastError()
+	} else {
+		db.state.mutex.Store(true)
+	}
+	return
+}
+
+/* opts combines HDBT* flags, e.g. uint8(HDBTLARGE)|Deflate.HDBOpts() */
 func (db *HDB) Tune(bnum int64, apow int8, fpow int8, opts uint8) (err error) {
+	if err = db.state.lockLive(); err != nil {
+		return
+	}
+	defer db.state.unlock()
 	if !C.tchdbtune(db.c_db, C.int64_t(bnum), C.int8_t(apow), C.int8_t(fpow), C.uint8_t(opts)) {
 		err = db.LastError()
 	}
@@ -13,6 +35,10 @@ func (db *HDB) Tune(bnum int64, apow int8, fpow int8, opts uint8) (err error) {
 }
 
 func (db *HDB) SetCache(rcnum int32) (err error) {
+	if err = db.state.lockLive(); err != nil {
+		return
+	}
+	defer db.state.unlock()
 	if !C.tchdbsetcache(db.c_db, C.int32_t(rcnum)) {
 		err = db.LastError()
 	}
@@ -20,6 +46,10 @@ func (db *HDB) SetCache(rcnum int32) (err error) {
 }
 
 func (db *HDB) SetExtraMemorySize(xmsiz int64) (err error) {
+	if err = db.state.lockLive(); err != nil {
+		return
+	}
+	defer db.state.unlock()
 	if !C.tchdbsetxmsiz(db.c_db, C.int64_t(xmsiz)) {
 		err = db.LastError()
 	}
@@ -27,6 +57,10 @@ func (db *HDB) SetExtraMemorySize(xmsiz int64) (err error) {
 }
 
 func (db *HDB) SetDefragStepSize(dfunit int32) (err error) {
+	if err = db.state.lockLive(); err != nil {
+		return
+	}
+	defer db.state.unlock()
 	if !C.tchdbsetdfunit(db.c_db, C.int32_t(dfunit)) {
 		err = db.LastError()
 	}
@@ -34,9 +68,29 @@ func (db *HDB) SetDefragStepSize(dfunit int32) (err error) {
 }
 
 func (db *HDB) PutAsync(key []byte, value []byte) (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
 	if !C.tchdbputasync(db.c_db,
-		unsafe.Pointer(&key[0]), C.int(len(key)),
-		unsafe.Pointer(&value[0]), C.int(len(value))) {
+		bytesPtr(key), C.int(len(key)),
+		bytesPtr(value), C.int(len(value))) {
+		err = db.LastError()
+	}
+	return
+}
+
+/*
+ * rebuilds the database file. zero or negative sizes are derived from the
+ * current record count; negative apow/fpow and opts of 0xff keep the current
+ * settings
+ */
+func (db *HDB) Optimize(bnum int64, apow int8, fpow int8, opts uint8) (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	if !C.tchdboptimize(db.c_db, C.int64_t(bnum), C.int8_t(apow), C.int8_t(fpow), C.uint8_t(opts)) {
 		err = db.LastError()
 	}
 	return
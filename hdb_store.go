@@ -0,0 +1,18 @@
+package tokyocabinet
+
+/*
+ * adapts an HDB to the Store interface, whose Open takes no mode: the
+ * database is opened for writing and created if need be. every other method
+ * is the HDB's own.
+ */
+type HDBStore struct {
+	*HDB
+}
+
+func NewHDBStore(db *HDB) *HDBStore {
+	return &HDBStore{db}
+}
+
+func (s *HDBStore) Open(path string) error {
+	return s.HDB.Open(path, HDBOWRITER|HDBOCREAT)
+}
@@ -1,8 +1,11 @@
 package tokyocabinet
 
 import "bytes"
+import "errors"
+import "context"
 import "io/ioutil"
 import "os"
+import "path/filepath"
 import "testing"
 
 func hdb_assertOpen(t *testing.T, filename string, flags int) HDB {
@@ -212,3 +215,245 @@ func TestHDBTransactions(t *testing.T) {
 	hdb_assertCommitTxn(t, db)
 	hdb_assertGetValue(t, db, "txn-2", "set-inside-txn")
 }
+
+func hdb_assertFwmKeySet(t *testing.T, db HDB, prefix string, max int, expectedKeys []string) {
+	keys := db.FwmKeys([]byte(prefix), max)
+	if len(keys) != len(expectedKeys) {
+		t.Fatalf("Expected %d keys with prefix %s, found %d", len(expectedKeys), prefix, len(keys))
+	}
+	seenKeys := make(map[string]bool)
+	for _, key := range keys {
+		seenKeys[string(key)] = true
+	}
+	for i := 0; i < len(expectedKeys); i++ {
+		if !seenKeys[expectedKeys[i]] {
+			t.Fatalf("Did not see expected key %s with prefix %s", expectedKeys[i], prefix)
+		}
+	}
+}
+
+func TestHDBFwmKeys(t *testing.T) {
+	db := hdb_assertOpen(t, "testfwm.hdb", HDBOWRITER|HDBOCREAT|HDBOTRUNC)
+	defer hdb_assertClose(t, db)
+
+	hdb_assertPut(t, db, "user:1", "alice")
+	hdb_assertPut(t, db, "user:2", "bob")
+	hdb_assertPut(t, db, "group:1", "staff")
+
+	hdb_assertFwmKeySet(t, db, "user:", -1, []string{"user:1", "user:2"})
+	hdb_assertFwmKeySet(t, db, "group:", -1, []string{"group:1"})
+	hdb_assertFwmKeySet(t, db, "nobody:", -1, []string{})
+	if keys := db.FwmKeys([]byte("user:"), 1); len(keys) != 1 {
+		t.Fatalf("Expected max to limit results to 1 key, found %d", len(keys))
+	}
+}
+
+func TestHDBMaintenance(t *testing.T) {
+	// Optimize replaces the file, so it must stay on disk for the whole test
+	filename := filepath.Join(t.TempDir(), "testmaint.hdb")
+	var db HDB = *NewHDB()
+	if err := db.Open(filename, HDBOWRITER|HDBOCREAT|HDBOTRUNC); err != nil {
+		t.Fatalf("Unable to open %s: %s", filename, err)
+	}
+	defer hdb_assertClose(t, db)
+
+	hdb_assertPut(t, db, "hello", "world")
+	hdb_assertPut(t, db, "goodbye", "world")
+	if db.Path() == "" {
+		t.Fatalf("Expected an open database to report its path")
+	}
+	if n := db.RecordCount(); n != 2 {
+		t.Fatalf("Expected 2 records, found %d", n)
+	}
+	if db.FileSize() == 0 {
+		t.Fatalf("Expected a non-zero file size")
+	}
+
+	if err := db.Optimize(0, -1, -1, 0xff); err != nil {
+		t.Fatalf("Unable to optimize database: %s", err)
+	}
+	if n := db.RecordCount(); n != 2 {
+		t.Fatalf("Expected 2 records after optimizing, found %d", n)
+	}
+
+	tf, err := ioutil.TempFile("", "tctest")
+	if err != nil {
+		t.Fatalf("Unable to create temporary file: %s", err)
+	}
+	if err := db.Copy(tf.Name()); err != nil {
+		t.Fatalf("Unable to copy database: %s", err)
+	}
+	copied := hdb_assertOpen(t, tf.Name(), HDBOREADER)
+	hdb_assertGetValue(t, copied, "hello", "world")
+	hdb_assertClose(t, copied)
+
+	if err := db.Vanish(); err != nil {
+		t.Fatalf("Unable to vanish database: %s", err)
+	}
+	if n := db.RecordCount(); n != 0 {
+		t.Fatalf("Expected no records after vanishing, found %d", n)
+	}
+}
+
+func TestHDBIterCancel(t *testing.T) {
+	db := hdb_assertOpen(t, "testitercancel.hdb", HDBOWRITER|HDBOCREAT|HDBOTRUNC)
+	defer hdb_assertClose(t, db)
+
+	hdb_assertPut(t, db, "a", "1")
+	hdb_assertPut(t, db, "b", "2")
+	hdb_assertPut(t, db, "c", "3")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result_chan, err_chan := db.IterKeysContext(ctx)
+	if _, ok := <-result_chan; !ok {
+		t.Fatalf("Expected at least one key before cancelling")
+	}
+	cancel()
+	for range result_chan {
+	}
+	if err := <-err_chan; err != nil && err != context.Canceled {
+		t.Fatalf("Unexpected error after cancelling iteration: %s", err)
+	}
+	if _, ok := <-err_chan; ok {
+		t.Fatalf("Expected error channel to be closed")
+	}
+}
+
+func TestHDBIterInitFailure(t *testing.T) {
+	db := NewHDB()
+	defer db.Del()
+
+	// the database was never opened, so the iterator cannot be initialized
+	result_chan, err_chan := db.IterKeys()
+	if err := <-err_chan; err == nil {
+		t.Fatalf("Expected an error iterating over an unopened database")
+	}
+	if _, ok := <-result_chan; ok {
+		t.Fatalf("Expected result channel to be closed")
+	}
+}
+
+func TestHDBIterBusy(t *testing.T) {
+	db := hdb_assertOpen(t, "testiterbusy.hdb", HDBOWRITER|HDBOCREAT|HDBOTRUNC)
+	defer hdb_assertClose(t, db)
+
+	hdb_assertPut(t, db, "a", "1")
+	hdb_assertPut(t, db, "b", "2")
+
+	result_chan, err_chan := db.IterKeys()
+	if _, ok := <-result_chan; !ok {
+		t.Fatalf("Expected at least one key from the first iterator")
+	}
+	busy_chan, busy_err_chan := db.IterKeys()
+	if err := <-busy_err_chan; err != ErrBusy {
+		t.Fatalf("Expected ErrBusy from a second iterator, got %v", err)
+	}
+	if _, ok := <-busy_chan; ok {
+		t.Fatalf("Expected result channel to be closed")
+	}
+	for range result_chan {
+	}
+	if err := <-err_chan; err != nil {
+		t.Fatalf("Error while iterating over keys: %s", err)
+	}
+}
+
+func TestHDBRangeFunc(t *testing.T) {
+	db := hdb_assertOpen(t, "testrangefunc.hdb", HDBOWRITER|HDBOCREAT|HDBOTRUNC)
+	defer hdb_assertClose(t, db)
+
+	hdb_assertPut(t, db, "hello", "world")
+	hdb_assertPut(t, db, "goodbye", "moon")
+
+	seen := make(map[string]string)
+	it := db.Iter()
+	for key, value := range it.All() {
+		seen[string(key)] = string(value)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("Error while iterating over records: %s", err)
+	}
+	if len(seen) != 2 || seen["hello"] != "world" || seen["goodbye"] != "moon" {
+		t.Fatalf("Unexpected records during iteration: %v", seen)
+	}
+
+	count := 0
+	for range db.Keys() {
+		count++
+		break
+	}
+	if count != 1 {
+		t.Fatalf("Expected to stop after 1 key, saw %d", count)
+	}
+}
+
+func TestHDBEmpty(t *testing.T) {
+	db := hdb_assertOpen(t, "testempty.hdb", HDBOWRITER|HDBOCREAT|HDBOTRUNC)
+	defer hdb_assertClose(t, db)
+	hdb_assertPut(t, db, "", "empty key")
+	hdb_assertGetValue(t, db, "", "empty key")
+	hdb_assertPut(t, db, "foo", "")
+	hdb_assertGetValue(t, db, "foo", "")
+	if size := hdb_assertGetSize(t, db, "foo"); size != 0 {
+		t.Fatalf("Expected an empty value, got size %d", size)
+	}
+	hdb_assertPutCat(t, db, "foo", "")
+	hdb_assertPutCat(t, db, "foo", "bar")
+	hdb_assertGetValue(t, db, "foo", "bar")
+	hdb_assertPutKeep(t, db, "bar", "")
+	hdb_assertPutAsync(t, db, "baz", "")
+	if err := db.Put([]byte("nil"), nil); err != nil {
+		t.Fatalf("Unable to put a nil value: %s", err)
+	}
+	hdb_assertGetValue(t, db, "nil", "")
+	hdb_assertFwmKeySet(t, db, "", -1, []string{"", "foo", "bar", "baz", "nil"})
+	if err := db.Remove(nil); err != nil {
+		t.Fatalf("Unable to remove the empty key: %s", err)
+	}
+	if _, err := db.Get([]byte{}); !errors.Is(err, ErrNoRecord) {
+		t.Fatalf("Expected the empty key to be removed, got %v", err)
+	}
+}
+
+func TestHDBGetInto(t *testing.T) {
+	db := hdb_assertOpen(t, "testgetinto.hdb", HDBOWRITER|HDBOCREAT|HDBOTRUNC)
+	defer hdb_assertClose(t, db)
+	hdb_assertPut(t, db, "foo", "foobar")
+	hdb_assertPut(t, db, "empty", "")
+
+	buf := make([]byte, 16)
+	n, err := db.GetInto([]byte("foo"), buf)
+	if err != nil || string(buf[:n]) != "foobar" {
+		t.Fatalf("Expected foobar, got %q (%v)", buf[:n], err)
+	}
+	n, err = db.GetInto([]byte("foo"), buf[:3])
+	if err != nil || string(buf[:n]) != "foo" {
+		t.Fatalf("Expected a truncated value, got %q (%v)", buf[:n], err)
+	}
+	n, err = db.GetInto([]byte("empty"), buf)
+	if err != nil || n != 0 {
+		t.Fatalf("Expected an empty value, got %d bytes (%v)", n, err)
+	}
+	if _, err = db.GetInto([]byte("missing"), buf); !errors.Is(err, ErrNoRecord) {
+		t.Fatalf("Expected ErrNoRecord, got %v", err)
+	}
+
+	var seen string
+	err = db.GetFunc([]byte("foo"), func(value []byte) { seen = string(value) })
+	if err != nil || seen != "foobar" {
+		t.Fatalf("Expected foobar, got %q (%v)", seen, err)
+	}
+	called := false
+	err = db.GetFunc([]byte("missing"), func(value []byte) { called = true })
+	if called || !errors.Is(err, ErrNoRecord) {
+		t.Fatalf("Expected ErrNoRecord without a call, got %v", err)
+	}
+
+	key := []byte("foo")
+	allocs := testing.AllocsPerRun(100, func() {
+		db.GetInto(key, buf)
+	})
+	if allocs != 0 {
+		t.Fatalf("Expected GetInto not to allocate, got %v allocations", allocs)
+	}
+}
@@ -0,0 +1,38 @@
+package tokyocabinet
+
+import "iter"
+
+/*
+ * a loop over the records of a database that remembers how it ended. range
+ * over Keys, Records or All, then check Err: a database's own Keys, Records
+ * and All end quietly on an error, just as they do at the last record.
+ * loops over the same database wait for one another, so the body must not
+ * start another one.
+ */
+type Iterator struct {
+	keys    func(yield func([]byte) bool) error
+	records func(yield func([]byte, []byte) bool) error
+	err     error
+}
+
+func (it *Iterator) Keys() iter.Seq[[]byte] {
+	return func(yield func([]byte) bool) {
+		it.err = it.keys(yield)
+	}
+}
+
+/* as Keys, yielding each value along with its key */
+func (it *Iterator) Records() iter.Seq2[[]byte, []byte] {
+	return func(yield func([]byte, []byte) bool) {
+		it.err = it.records(yield)
+	}
+}
+
+func (it *Iterator) All() iter.Seq2[[]byte, []byte] {
+	return it.Records()
+}
+
+/* the error, if any, that ended the most recent loop over the iterator */
+func (it *Iterator) Err() error {
+	return it.err
+}
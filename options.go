@@ -0,0 +1,250 @@
+package tokyocabinet
+
+// #cgo pkg-config: tokyocabinet
+// #include <tchdb.h>
+// #include <tcbdb.h>
+import "C"
+
+/* how HDB and BDB records are compressed in the file */
+type Compression int
+
+const (
+	NoCompression Compression = iota
+	Deflate
+	BZIP2
+	TCBS
+	/* records pass through the Codec given to SetCodec or WithCodec */
+	Custom
+)
+
+/* the HDBT* flag selecting c, for combining into the opts given to HDB.Tune */
+func (c Compression) HDBOpts() uint8 {
+	switch c {
+	case Deflate:
+		return C.HDBTDEFLATE
+	case BZIP2:
+		return C.HDBTBZIP
+	case TCBS:
+		return C.HDBTTCBS
+	case Custom:
+		return C.HDBTEXCODEC
+	}
+	return 0
+}
+
+/* the BDBT* flag selecting c, for combining into the opts given to BDB.Tune */
+func (c Compression) BDBOpts() uint8 {
+	switch c {
+	case Deflate:
+		return C.BDBTDEFLATE
+	case BZIP2:
+		return C.BDBTBZIP
+	case TCBS:
+		return C.BDBTTCBS
+	case Custom:
+		return C.BDBTEXCODEC
+	}
+	return 0
+}
+
+func compressionOf(opts uint8, deflate int, bzip int, tcbs int, excodec int) Compression {
+	switch {
+	case int(opts)&deflate != 0:
+		return Deflate
+	case int(opts)&bzip != 0:
+		return BZIP2
+	case int(opts)&tcbs != 0:
+		return TCBS
+	case int(opts)&excodec != 0:
+		return Custom
+	}
+	return NoCompression
+}
+
+/* configures a database opened by OpenHDB, OpenBDB or OpenFDB */
+type Option func(*openConfig)
+
+type openConfig struct {
+	readOnly    bool
+	create      bool
+	truncate    bool
+	noLock      bool
+	nonBlocking bool
+	mutex       bool
+
+	bnum        int64
+	compression Compression
+	codec       Codec
+	cache       int32
+
+	width  int32
+	limsiz int64
+}
+
+/* opens as a reader; by default databases are opened as a writer */
+func ReadOnly() Option {
+	return func(c *openConfig) { c.readOnly = true }
+}
+
+/* creates the database file if it does not exist */
+func Create() Option {
+	return func(c *openConfig) { c.create = true }
+}
+
+/* empties the database file when opening */
+func Truncate() Option {
+	return func(c *openConfig) { c.truncate = true }
+}
+
+/* opens without locking the database file */
+func NoLock() Option {
+	return func(c *openConfig) { c.noLock = true }
+}
+
+/* fails instead of waiting if the database file is locked */
+func NonBlockingLock() Option {
+	return func(c *openConfig) { c.nonBlocking = true }
+}
+
+/* enables the native mutex; see SetMutex */
+func ThreadSafe() Option {
+	return func(c *openConfig) { c.mutex = true }
+}
+
+/* number of hash buckets; ignored by FDB */
+func WithBuckets(bnum int64) Option {
+	return func(c *openConfig) { c.bnum = bnum }
+}
+
+/* record compression; ignored by FDB */
+func WithCompression(compression Compression) Option {
+	return func(c *openConfig) { c.compression = compression }
+}
+
+/* record compression by codec, as with SetCodec; ignored by FDB */
+func WithCodec(codec Codec) Option {
+	return func(c *openConfig) {
+		c.compression = Custom
+		c.codec = codec
+	}
+}
+
+/* number of records (HDB) or leaf nodes (BDB) to cache; ignored by FDB */
+func WithCache(num int32) Option {
+	return func(c *openConfig) { c.cache = num }
+}
+
+/* width of each FDB record; ignored by HDB and BDB */
+func WithWidth(width int32) Option {
+	return func(c *openConfig) { c.width = width }
+}
+
+/* limit on the size of an FDB file; ignored by HDB and BDB */
+func WithLimitSize(limsiz int64) Option {
+	return func(c *openConfig) { c.limsiz = limsiz }
+}
+
+func newOpenConfig(opts []Option) *openConfig {
+	c := &openConfig{}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+func (c *openConfig) tuned() bool {
+	return c.bnum > 0 || c.compression != NoCompression
+}
+
+/* the reader, writer, creat, trunc and lock bits are shared by every type */
+func (c *openConfig) omode() int {
+	omode := HDBOWRITER
+	if c.readOnly {
+		omode = HDBOREADER
+	}
+	if c.create {
+		omode |= HDBOCREAT
+	}
+	if c.truncate {
+		omode |= HDBOTRUNC
+	}
+	if c.noLock {
+		omode |= HDBONOLCK
+	}
+	if c.nonBlocking {
+		omode |= HDBOLCKNB
+	}
+	return omode
+}
+
+/* a ready handle; the native object is released if opening fails */
+func OpenHDB(path string, opts ...Option) (db *HDB, err error) {
+	c := newOpenConfig(opts)
+	db = NewHDB()
+	if c.mutex {
+		err = db.SetMutex()
+	}
+	if err == nil && c.tuned() {
+		err = db.Tune(c.bnum, -1, -1, c.compression.HDBOpts())
+	}
+	if err == nil && c.codec != nil {
+		err = db.SetCodec(c.codec)
+	}
+	if err == nil && c.cache > 0 {
+		err = db.SetCache(c.cache)
+	}
+	if err == nil {
+		err = db.Open(path, c.omode())
+	}
+	if err != nil {
+		db.Del()
+		db = nil
+	}
+	return
+}
+
+/* a ready handle; the native object is released if opening fails */
+func OpenBDB(path string, opts ...Option) (db *BDB, err error) {
+	c := newOpenConfig(opts)
+	db = NewBDB()
+	if c.mutex {
+		err = db.SetMutex()
+	}
+	if err == nil && c.tuned() {
+		err = db.Tune(0, 0, c.bnum, -1, -1, c.compression.BDBOpts())
+	}
+	if err == nil && c.codec != nil {
+		err = db.SetCodec(c.codec)
+	}
+	if err == nil && c.cache > 0 {
+		err = db.SetCache(c.cache, 0)
+	}
+	if err == nil {
+		err = db.Open(path, c.omode())
+	}
+	if err != nil {
+		db.Del()
+		db = nil
+	}
+	return
+}
+
+/* a ready handle; the native object is released if opening fails */
+func OpenFDB(path string, opts ...Option) (db *FDB, err error) {
+	c := newOpenConfig(opts)
+	db = NewFDB()
+	if c.mutex {
+		err = db.SetMutex()
+	}
+	if err == nil && (c.width > 0 || c.limsiz > 0) {
+		err = db.Tune(c.width, c.limsiz)
+	}
+	if err == nil {
+		err = db.Open(path, c.omode())
+	}
+	if err != nil {
+		db.Del()
+		db = nil
+	}
+	return
+}
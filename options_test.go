@@ -0,0 +1,229 @@
+package tokyocabinet
+
+import "bytes"
+import "compress/flate"
+import "errors"
+import "io"
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "strconv"
+import "testing"
+
+func options_tempName(t *testing.T) string {
+	tf, err := ioutil.TempFile("", "tctest")
+	if err != nil {
+		t.Fatalf("Unable to create temporary file: %s", err)
+	}
+	tf.Close()
+	return tf.Name()
+}
+
+func TestOpenHDB(t *testing.T) {
+	filename := options_tempName(t)
+	defer os.Remove(filename)
+
+	db, err := OpenHDB(filename, Create(), Truncate(), WithBuckets(1024),
+		WithCompression(Deflate), WithCache(128))
+	if err != nil {
+		t.Fatalf("Unable to open %s: %s", filename, err)
+	}
+	hdb_assertPut(t, *db, "hello", "world")
+	hdb_assertClose(t, *db)
+	db.Del()
+
+	db, err = OpenHDB(filename, ReadOnly(), NonBlockingLock())
+	if err != nil {
+		t.Fatalf("Unable to reopen %s: %s", filename, err)
+	}
+	defer db.Del()
+	defer hdb_assertClose(t, *db)
+	hdb_assertGetValue(t, *db, "hello", "world")
+	if err := db.Put([]byte("hello"), []byte("again")); err == nil {
+		t.Fatalf("Expected an error writing to a read-only database")
+	}
+}
+
+func TestOpenBDB(t *testing.T) {
+	filename := options_tempName(t)
+	defer os.Remove(filename)
+
+	db, err := OpenBDB(filename, Create(), Truncate(), NoLock(),
+		WithBuckets(1024), WithCompression(Deflate), WithCache(64))
+	if err != nil {
+		t.Fatalf("Unable to open %s: %s", filename, err)
+	}
+	defer db.Del()
+	defer bdb_assertClose(t, *db)
+	bdb_assertPut(t, *db, "hello", "world")
+	bdb_assertGetValue(t, *db, "hello", "world")
+}
+
+func TestOpenFDB(t *testing.T) {
+	filename := options_tempName(t)
+	defer os.Remove(filename)
+
+	db, err := OpenFDB(filename, Create(), Truncate(), WithWidth(4))
+	if err != nil {
+		t.Fatalf("Unable to open %s: %s", filename, err)
+	}
+	defer db.Del()
+	defer fdb_assertClose(t, *db)
+	fdb_assertPut(t, *db, 1, "abcdef")
+	fdb_assertGetValue(t, *db, 1, "abcd")
+}
+
+func TestOpenFailure(t *testing.T) {
+	missing := filepath.Join(os.TempDir(), "tctest-missing", "nofile")
+	if db, err := OpenHDB(missing); err == nil || db != nil {
+		t.Fatalf("Expected opening a missing file without Create to fail")
+	}
+	if db, err := OpenBDB(missing); err == nil || db != nil {
+		t.Fatalf("Expected opening a missing file without Create to fail")
+	}
+	if db, err := OpenFDB(missing); err == nil || db != nil {
+		t.Fatalf("Expected opening a missing file without Create to fail")
+	}
+}
+
+/* Custom compression backed by compress/flate */
+type options_flateCodec struct{}
+
+func (options_flateCodec) Encode(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (options_flateCodec) Decode(data []byte) ([]byte, error) {
+	return io.ReadAll(flate.NewReader(bytes.NewReader(data)))
+}
+
+var options_value = bytes.Repeat([]byte("compressible "), 80)
+
+func options_fill(t *testing.T, s Store) {
+	for i := 0; i < 500; i++ {
+		if err := s.Put([]byte("key"+strconv.Itoa(i)), options_value); err != nil {
+			t.Fatalf("Unable to put: %s", err)
+		}
+	}
+}
+
+func options_assertFilled(t *testing.T, s Store) {
+	value, err := s.Get([]byte("key42"))
+	if err != nil || !bytes.Equal(value, options_value) {
+		t.Fatalf("Unexpected value after reopening: %v", err)
+	}
+}
+
+func options_fileSize(t *testing.T, filename string) int64 {
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatalf("Unable to stat %s: %s", filename, err)
+	}
+	return info.Size()
+}
+
+/* the size of a filled HDB written with c */
+func options_hdbSize(t *testing.T, c Compression, opts ...Option) int64 {
+	filename := options_tempName(t)
+	defer os.Remove(filename)
+
+	db, err := OpenHDB(filename, append(opts, Create(), Truncate(),
+		WithBuckets(1024), WithCompression(c))...)
+	if err != nil {
+		t.Fatalf("Unable to open %s: %s", filename, err)
+	}
+	options_fill(t, NewHDBStore(db))
+	hdb_assertClose(t, *db)
+	db.Del()
+
+	// reopened without WithCompression, so the flags come from the file
+	db, err = OpenHDB(filename, append(opts, ReadOnly())...)
+	if err != nil {
+		t.Fatalf("Unable to reopen %s: %s", filename, err)
+	}
+	defer db.Del()
+	defer hdb_assertClose(t, *db)
+	if db.Compression() != c || db.Options()&c.HDBOpts() != c.HDBOpts() {
+		t.Fatalf("Expected compression %d, got %d (options %#x)", c, db.Compression(), db.Options())
+	}
+	options_assertFilled(t, NewHDBStore(db))
+	return options_fileSize(t, filename)
+}
+
+/* the size of a filled BDB written with c */
+func options_bdbSize(t *testing.T, c Compression, opts ...Option) int64 {
+	filename := options_tempName(t)
+	defer os.Remove(filename)
+
+	db, err := OpenBDB(filename, append(opts, Create(), Truncate(),
+		WithBuckets(1024), WithCompression(c))...)
+	if err != nil {
+		t.Fatalf("Unable to open %s: %s", filename, err)
+	}
+	options_fill(t, NewBDBStore(db))
+	bdb_assertClose(t, *db)
+	db.Del()
+
+	db, err = OpenBDB(filename, append(opts, ReadOnly())...)
+	if err != nil {
+		t.Fatalf("Unable to reopen %s: %s", filename, err)
+	}
+	defer db.Del()
+	defer bdb_assertClose(t, *db)
+	if db.Compression() != c || db.Options()&c.BDBOpts() != c.BDBOpts() {
+		t.Fatalf("Expected compression %d, got %d (options %#x)", c, db.Compression(), db.Options())
+	}
+	options_assertFilled(t, NewBDBStore(db))
+	return options_fileSize(t, filename)
+}
+
+func TestCompression(t *testing.T) {
+	if HDBTDEFLATE == HDBTLARGE || BDBTDEFLATE == BDBTLARGE {
+		t.Fatalf("Expected the deflate flags to differ from the large file flags")
+	}
+
+	plain := options_hdbSize(t, NoCompression)
+	for _, c := range []Compression{Deflate, BZIP2, TCBS} {
+		if size := options_hdbSize(t, c); size >= plain {
+			t.Fatalf("Expected HDB compression %d to shrink the file: %d >= %d", c, size, plain)
+		}
+	}
+	if size := options_hdbSize(t, Custom, WithCodec(options_flateCodec{})); size >= plain {
+		t.Fatalf("Expected a custom HDB codec to shrink the file: %d >= %d", size, plain)
+	}
+
+	plain = options_bdbSize(t, NoCompression)
+	for _, c := range []Compression{Deflate, BZIP2, TCBS} {
+		if size := options_bdbSize(t, c); size >= plain {
+			t.Fatalf("Expected BDB compression %d to shrink the file: %d >= %d", c, size, plain)
+		}
+	}
+	if size := options_bdbSize(t, Custom, WithCodec(options_flateCodec{})); size >= plain {
+		t.Fatalf("Expected a custom BDB codec to shrink the file: %d >= %d", size, plain)
+	}
+}
+
+func TestCompressionWithoutCodec(t *testing.T) {
+	filename := options_tempName(t)
+	defer os.Remove(filename)
+
+	db, err := OpenHDB(filename, Create(), Truncate(), WithCodec(options_flateCodec{}))
+	if err != nil {
+		t.Fatalf("Unable to open %s: %s", filename, err)
+	}
+	hdb_assertClose(t, *db)
+	db.Del()
+
+	if _, err := OpenHDB(filename); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("Expected ErrInvalid opening a custom-compressed file without a codec, got %v", err)
+	}
+}
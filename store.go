@@ -0,0 +1,35 @@
+package tokyocabinet
+
+import "iter"
+
+/*
+ * the operations shared by every key-value database type, so that backends
+ * can be swapped by configuration. Open opens the database for writing,
+ * creating it if need be; ADB takes its open mode as part of the name
+ * instead. HDB, BDB and FDB take an open mode of their own, so wrap them
+ * with NewHDBStore, NewBDBStore and NewFDBStore; FDBStore also takes
+ * decimal-string keys in place of integers.
+ */
+type Store interface {
+	Open(path string) error
+	Close() error
+	BeginTxn() error
+	CommitTxn() error
+	AbortTxn() error
+	Put(key []byte, value []byte) error
+	PutKeep(key []byte, value []byte) error
+	PutCat(key []byte, value []byte) error
+	Remove(key []byte) error
+	Get(key []byte) ([]byte, error)
+	Size(key []byte) (int, error)
+	Sync() error
+	Keys() iter.Seq[[]byte]
+	Records() iter.Seq2[[]byte, []byte]
+	All() iter.Seq2[[]byte, []byte]
+	Iter() *Iterator
+}
+
+var _ Store = (*HDBStore)(nil)
+var _ Store = (*BDBStore)(nil)
+var _ Store = (*ADB)(nil)
+var _ Store = (*FDBStore)(nil)
@@ -0,0 +1,114 @@
+package tokyocabinet
+
+import "bytes"
+import "errors"
+import "path/filepath"
+import "testing"
+
+func store_assertGetValue(t *testing.T, s Store, key string, expected string) {
+	value, err := s.Get([]byte(key))
+	if err != nil {
+		t.Fatalf("Unable to retrieve value for key %s: %s", key, err)
+	}
+	if bytes.Compare([]byte(expected), value) != 0 {
+		t.Fatalf("Value for key %s came back incorrect (expected: %s; got: %s)", key, []byte(expected), value)
+	}
+}
+
+func store_exercise(t *testing.T, s Store) {
+	if err := s.Put([]byte("1"), []byte("world")); err != nil {
+		t.Fatalf("Unable to put: %s", err)
+	}
+	if err := s.PutCat([]byte("1"), []byte("!")); err != nil {
+		t.Fatalf("Unable to put: %s", err)
+	}
+	store_assertGetValue(t, s, "1", "world!")
+	if size, err := s.Size([]byte("1")); err != nil || size != 6 {
+		t.Fatalf("Unexpected size %d for key 1: %v", size, err)
+	}
+
+	if err := s.PutKeep([]byte("2"), []byte("first")); err != nil {
+		t.Fatalf("Unable to put: %s", err)
+	}
+	if err := s.PutKeep([]byte("2"), []byte("second")); err != nil {
+		t.Fatalf("Unable to put: %s", err)
+	}
+	store_assertGetValue(t, s, "2", "first")
+
+	if err := s.BeginTxn(); err != nil {
+		t.Fatalf("Unable to begin transaction: %s", err)
+	}
+	if err := s.Put([]byte("2"), []byte("inside")); err != nil {
+		t.Fatalf("Unable to put: %s", err)
+	}
+	if err := s.AbortTxn(); err != nil {
+		t.Fatalf("Unable to abort transaction: %s", err)
+	}
+	store_assertGetValue(t, s, "2", "first")
+
+	seen := make(map[string]string)
+	it := s.Iter()
+	for key, value := range it.All() {
+		seen[string(key)] = string(value)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("Error while iterating over records: %s", err)
+	}
+	if len(seen) != 2 || seen["1"] != "world!" || seen["2"] != "first" {
+		t.Fatalf("Unexpected records during iteration: %v", seen)
+	}
+
+	if err := s.Remove([]byte("1")); err != nil {
+		t.Fatalf("Unable to remove: %s", err)
+	}
+	if _, err := s.Get([]byte("1")); !errors.Is(err, ErrNoRecord) {
+		t.Fatalf("Expected ErrNoRecord for removed key, got %v", err)
+	}
+	if err := s.Sync(); err != nil {
+		t.Fatalf("Unable to sync: %s", err)
+	}
+}
+
+/* closes s and opens it again through the interface, keeping its records */
+func store_assertReopen(t *testing.T, s Store, path string) {
+	if err := s.Close(); err != nil {
+		t.Fatalf("Unable to close %s: %s", path, err)
+	}
+	if err := s.Open(path); err != nil {
+		t.Fatalf("Unable to reopen %s: %s", path, err)
+	}
+	store_assertGetValue(t, s, "2", "first")
+}
+
+/* runs the workload on a fresh database at path, opened through s */
+func store_assertBackend(t *testing.T, s Store, path string) {
+	if err := s.Open(path); err != nil {
+		t.Fatalf("Unable to open %s: %s", path, err)
+	}
+	store_exercise(t, s)
+	store_assertReopen(t, s, path)
+	if err := s.Close(); err != nil {
+		t.Fatalf("Unable to close %s: %s", path, err)
+	}
+}
+
+func TestStore(t *testing.T) {
+	// removed with its contents once the test ends
+	dir := t.TempDir()
+
+	hdb := NewHDB()
+	defer hdb.Del()
+	store_assertBackend(t, NewHDBStore(hdb), filepath.Join(dir, "teststore.hdb"))
+
+	bdb := NewBDB()
+	defer bdb.Del()
+	store_assertBackend(t, NewBDBStore(bdb), filepath.Join(dir, "teststore.bdb"))
+
+	fdb := NewFDB()
+	defer fdb.Del()
+	store_assertBackend(t, NewFDBStore(fdb), filepath.Join(dir, "teststore.fdb"))
+
+	adb := NewADB()
+	defer adb.Del()
+	store_assertBackend(t, adb, filepath.Join(dir, "teststore.tch"))
+}
@@ -0,0 +1,325 @@
+package tokyocabinet
+
+// #cgo pkg-config: tokyocabinet
+// #include <tctdb.h>
+//
+// static TDBIDX *tdbidx(TCTDB *tdb, int i) {
+//   return tdb->idxs + i;
+// }
+import "C"
+
+import "unsafe"
+
+const TDBFOPEN int = C.TDBFOPEN
+const TDBFFATAL int = C.TDBFFATAL
+
+const TDBTLARGE int = C.TDBTLARGE
+const TDBTDEFLATE int = C.TDBTDEFLATE
+const TDBTBZIP int = C.TDBTBZIP
+const TDBTTCBS int = C.TDBTTCBS
+const TDBTEXCODEC int = C.TDBTEXCODEC
+
+const TDBOREADER int = C.TDBOREADER
+const TDBOWRITER int = C.TDBOWRITER
+const TDBOCREAT int = C.TDBOCREAT
+const TDBOTRUNC int = C.TDBOTRUNC
+const TDBONOLCK int = C.TDBONOLCK
+const TDBOLCKNB int = C.TDBOLCKNB
+
+const TDBITLEXICAL int = C.TDBITLEXICAL
+const TDBITDECIMAL int = C.TDBITDECIMAL
+const TDBITTOKEN int = C.TDBITTOKEN
+const TDBITQGRAM int = C.TDBITQGRAM
+const TDBITOPT int = C.TDBITOPT
+const TDBITVOID int = C.TDBITVOID
+const TDBITKEEP int = C.TDBITKEEP
+
+func ECodeNameTDB(ecode int) string {
+	return C.GoString(C.tctdberrmsg(C.int(ecode)))
+}
+
+type TDB struct {
+	c_db  *C.TCTDB
+	state *handle
+}
+
+type TDBIndex struct {
+	Name string
+	Type int
+}
+
+func NewTDB() *TDB {
+	c_db := C.tctdbnew()
+	state := newHandle("TDB", func() { C.tctdbdel(c_db) })
+	return &TDB{c_db, state}
+}
+
+/* safe to call more than once; every other method fails afterwards */
+func (db *TDB) Del() {
+	if db.state.release() {
+		C.tctdbdel(db.c_db)
+		db.c_db = nil
+	}
+}
+
+/* TCEINVALID once the handle has been deleted */
+func (db *TDB) LastECode() int {
+	if db.state.live() != nil {
+		return TCINVALID
+	}
+	return int(C.tctdbecode(db.c_db))
+}
+
+func (db *TDB) LastError() error {
+	if err := db.state.live(); err != nil {
+		return err
+	}
+	code := db.LastECode()
+	return NewTokyoCabinetError(code, ECodeNameTDB(code))
+}
+
+func (db *TDB) Open(path string, omode int) (err error) {
+	if err = db.state.lockLive(); err != nil {
+		return
+	}
+	defer db.state.unlock()
+	c_path := C.CString(path)
+	defer C.free(unsafe.Pointer(c_path))
+	if !C.tctdbopen(db.c_db, c_path, C.int(omode)) {
+		err = db.LastError()
+	} else {
+		db.state.open.Store(true)
+	}
+	return
+}
+
+/* closing a handle that is not open does nothing */
+func (db *TDB) Close() (err error) {
+	if db.state.lock() != nil {
+		return
+	}
+	defer db.state.unlock()
+	if !C.tctdbclose(db.c_db) {
+		err = db.LastError()
+	} else {
+		db.state.open.Store(false)
+	}
+	return
+}
+
+func (db *TDB) BeginTxn() (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	if !C.tctdbtranbegin(db.c_db) {
+		err = db.LastError()
+	}
+	return
+}
+
+func (db *TDB) CommitTxn() (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	if !C.tctdbtrancommit(db.c_db) {
+		err = db.LastError()
+	}
+	return
+}
+
+func (db *TDB) AbortTxn() (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	if !C.tctdbtranabort(db.c_db) {
+		err = db.LastError()
+	}
+	return
+}
+
+func (db *TDB) Put(pkey []byte, cols map[string][]byte) (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	c_cols := newTCMap(cols)
+	defer C.tcmapdel(c_cols)
+	if !C.tctdbput(db.c_db,
+		bytesPtr(pkey), C.int(len(pkey)),
+		c_cols) {
+		err = db.LastError()
+	}
+	return
+}
+
+func (db *TDB) PutKeep(pkey []byte, cols map[string][]byte) (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	c_cols := newTCMap(cols)
+	defer C.tcmapdel(c_cols)
+	if !C.tctdbputkeep(db.c_db,
+		bytesPtr(pkey), C.int(len(pkey)),
+		c_cols) {
+		if db.LastECode() == TCEKEEP {
+			return
+		}
+		err = db.LastError()
+	}
+	return
+}
+
+/* columns not already present in an existing row are added to it; others are kept */
+func (db *TDB) PutCat(pkey []byte, cols map[string][]byte) (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	c_cols := newTCMap(cols)
+	defer C.tcmapdel(c_cols)
+	if !C.tctdbputcat(db.c_db,
+		bytesPtr(pkey), C.int(len(pkey)),
+		c_cols) {
+		err = db.LastError()
+	}
+	return
+}
+
+func (db *TDB) Remove(pkey []byte) (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	if !C.tctdbout(db.c_db,
+		bytesPtr(pkey), C.int(len(pkey))) {
+		err = db.LastError()
+	}
+	return
+}
+
+func (db *TDB) Get(pkey []byte) (cols map[string][]byte, err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	c_cols := C.tctdbget(db.c_db,
+		bytesPtr(pkey), C.int(len(pkey)))
+	if c_cols != nil {
+		defer C.tcmapdel(c_cols)
+		cols = goMap(c_cols)
+	} else {
+		err = db.LastError()
+	}
+	return
+}
+
+func (db *TDB) Size(pkey []byte) (out int, err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	res := C.tctdbvsiz(db.c_db, bytesPtr(pkey), C.int(len(pkey)))
+	if res < 0 {
+		err = db.LastError()
+	} else {
+		out = int(res)
+	}
+	return
+}
+
+/* generates a new unique ID number, suitable for use as a primary key */
+func (db *TDB) GenUID() (uid int64, err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	res := C.tctdbgenuid(db.c_db)
+	if res < 0 {
+		err = db.LastError()
+	}
+	uid = int64(res)
+	return
+}
+
+/*
+ * itype is one of TDBITLEXICAL, TDBITDECIMAL, TDBITTOKEN or TDBITQGRAM, or
+ * TDBITOPT to optimize or TDBITVOID to remove an existing index. Or-ing in
+ * TDBITKEEP leaves an existing index alone instead of failing.
+ */
+func (db *TDB) SetIndex(name string, itype int) (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	c_name := C.CString(name)
+	defer C.free(unsafe.Pointer(c_name))
+	if !C.tctdbsetindex(db.c_db, c_name, C.int(itype)) {
+		if itype&TDBITKEEP != 0 && db.LastECode() == TCEKEEP {
+			return
+		}
+		err = db.LastError()
+	}
+	return
+}
+
+/* lists the indexes present on the opened database */
+func (db *TDB) Indexes() (idxs []TDBIndex) {
+	if db.state.enter() != nil {
+		return
+	}
+	defer db.state.exit()
+	num := int(db.c_db.inum)
+	idxs = make([]TDBIndex, 0, num)
+	for i := 0; i < num; i++ {
+		idx := C.tdbidx(db.c_db, C.int(i))
+		idxs = append(idxs, TDBIndex{C.GoString(idx.name), int(idx._type)})
+	}
+	return
+}
+
+func (db *TDB) Sync() (err error) {
+	if err = db.state.enter(); err != nil {
+		return
+	}
+	defer db.state.exit()
+	if !C.tctdbsync(db.c_db) {
+		err = db.LastError()
+	}
+	return
+}
+
+/* the returned map must be released with tcmapdel */
+func newTCMap(cols map[string][]byte) *C.TCMAP {
+	c_map := C.tcmapnew2(C.uint32_t(len(cols) + 1))
+	fillTCMap(c_map, cols)
+	return c_map
+}
+
+func fillTCMap(c_map *C.TCMAP, cols map[string][]byte) {
+	for name, value := range cols {
+		c_name := C.CString(name)
+		C.tcmapput(c_map,
+			unsafe.Pointer(c_name), C.int(len(name)),
+			bytesPtr(value), C.int(len(value)))
+		C.free(unsafe.Pointer(c_name))
+	}
+}
+
+func goMap(c_map *C.TCMAP) (cols map[string][]byte) {
+	cols = make(map[string][]byte, int(C.tcmaprnum(c_map)))
+	C.tcmapiterinit(c_map)
+	for {
+		var ksiz, vsiz C.int
+		kbuf := C.tcmapiternext(c_map, &ksiz)
+		if kbuf == nil {
+			break
+		}
+		vbuf := C.tcmapiterval(kbuf, &vsiz)
+		cols[string(C.GoBytes(kbuf, ksiz))] = C.GoBytes(vbuf, vsiz)
+	}
+	return
+}
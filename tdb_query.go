@@ -0,0 +1,237 @@
+package tokyocabinet
+
+// #cgo pkg-config: tokyocabinet
+// #include <tctdb.h>
+// extern int goTDBQueryProc(void *pkbuf, int pksiz, TCMAP *cols, void *op);
+import "C"
+
+import (
+	"runtime"
+	"runtime/cgo"
+	"unsafe"
+)
+
+const TDBQCSTREQ int = C.TDBQCSTREQ
+const TDBQCSTRINC int = C.TDBQCSTRINC
+const TDBQCSTRBW int = C.TDBQCSTRBW
+const TDBQCSTREW int = C.TDBQCSTREW
+const TDBQCSTRAND int = C.TDBQCSTRAND
+const TDBQCSTROR int = C.TDBQCSTROR
+const TDBQCSTROREQ int = C.TDBQCSTROREQ
+const TDBQCSTRRX int = C.TDBQCSTRRX
+const TDBQCNUMEQ int = C.TDBQCNUMEQ
+const TDBQCNUMGT int = C.TDBQCNUMGT
+const TDBQCNUMGE int = C.TDBQCNUMGE
+const TDBQCNUMLT int = C.TDBQCNUMLT
+const TDBQCNUMLE int = C.TDBQCNUMLE
+const TDBQCNUMBT int = C.TDBQCNUMBT
+const TDBQCNUMOREQ int = C.TDBQCNUMOREQ
+const TDBQCFTSPH int = C.TDBQCFTSPH
+const TDBQCFTSAND int = C.TDBQCFTSAND
+const TDBQCFTSOR int = C.TDBQCFTSOR
+const TDBQCFTSEX int = C.TDBQCFTSEX
+const TDBQCNEGATE int = C.TDBQCNEGATE
+const TDBQCNOIDX int = C.TDBQCNOIDX
+
+const TDBQOSTRASC int = C.TDBQOSTRASC
+const TDBQOSTRDESC int = C.TDBQOSTRDESC
+const TDBQONUMASC int = C.TDBQONUMASC
+const TDBQONUMDESC int = C.TDBQONUMDESC
+
+const TDBQPPUT int = C.TDBQPPUT
+const TDBQPOUT int = C.TDBQPOUT
+const TDBQPSTOP int = C.TDBQPSTOP
+
+const TDBMSUNION int = C.TDBMSUNION
+const TDBMSISECT int = C.TDBMSISECT
+const TDBMSDIFF int = C.TDBMSDIFF
+
+/*
+ * called once per matching row; cols may be modified in place and written
+ * back by returning TDBQPPUT, the row removed by returning TDBQPOUT, and the
+ * scan ended early by or-ing in TDBQPSTOP. the database is held for the
+ * duration of the scan, so the function must not use it.
+ */
+type TDBQueryProc func(pkey []byte, cols map[string][]byte) int
+
+/*
+ * a query should be released with Del when it is no longer needed. searches
+ * fail once the database it was created from is closed or deleted.
+ */
+type TDBQuery struct {
+	c_qry   *C.TDBQRY
+	db      *TDB
+	cleanup runtime.Cleanup
+}
+
+func NewTDBQuery(db *TDB) *TDBQuery {
+	q := &TDBQuery{db: db}
+	if db.state.enterLive() != nil {
+		return q
+	}
+	defer db.state.exit()
+	q.c_qry = C.tctdbqrynew(db.c_db)
+	q.cleanup = runtime.AddCleanup(q, func(c_qry *C.TDBQRY) {
+		reportLeak("TDBQuery")
+		C.tctdbqrydel(c_qry)
+	}, q.c_qry)
+	return q
+}
+
+/* safe to call more than once */
+func (q *TDBQuery) Del() {
+	if q.c_qry == nil {
+		return
+	}
+	q.cleanup.Stop()
+	C.tctdbqrydel(q.c_qry)
+	q.c_qry = nil
+}
+
+/* as the database's enter, failing once the query is deleted */
+func (q *TDBQuery) enter() error {
+	if q.c_qry == nil {
+		return ErrClosed
+	}
+	return q.db.state.enter()
+}
+
+/* as BDBCursor's exit, keeping the query reachable until the call is done */
+func (q *TDBQuery) exit() {
+	q.db.state.exit()
+	runtime.KeepAlive(q)
+}
+
+/* op is a TDBQC* operator, optionally or-ed with TDBQCNEGATE and TDBQCNOIDX */
+func (q *TDBQuery) AddCond(name string, op int, expr string) {
+	if q.c_qry == nil {
+		return
+	}
+	c_name := C.CString(name)
+	defer C.free(unsafe.Pointer(c_name))
+	c_expr := C.CString(expr)
+	defer C.free(unsafe.Pointer(c_expr))
+	C.tctdbqryaddcond(q.c_qry, c_name, C.int(op), c_expr)
+	runtime.KeepAlive(q)
+}
+
+/* an empty name orders by primary key */
+func (q *TDBQuery) SetOrder(name string, otype int) {
+	if q.c_qry == nil {
+		return
+	}
+	c_name := C.CString(name)
+	defer C.free(unsafe.Pointer(c_name))
+	C.tctdbqrysetorder(q.c_qry, c_name, C.int(otype))
+	runtime.KeepAlive(q)
+}
+
+/* negative max for infinite */
+func (q *TDBQuery) SetLimit(max int, skip int) {
+	if q.c_qry == nil {
+		return
+	}
+	C.tctdbqrysetlimit(q.c_qry, C.int(max), C.int(skip))
+	runtime.KeepAlive(q)
+}
+
+func (q *TDBQuery) Search() (pkeys [][]byte) {
+	if q.enter() != nil {
+		return
+	}
+	defer q.exit()
+	resList := C.tctdbqrysearch(q.c_qry)
+	defer C.tclistdel(resList)
+	pkeys = goList(resList)
+	return
+}
+
+/* removes every row matching the query */
+func (q *TDBQuery) SearchOut() (err error) {
+	if err = q.enter(); err != nil {
+		return
+	}
+	defer q.exit()
+	if !C.tctdbqrysearchout(q.c_qry) {
+		err = q.db.LastError()
+	}
+	return
+}
+
+func (q *TDBQuery) Proc(proc TDBQueryProc) (err error) {
+	if err = q.enter(); err != nil {
+		return
+	}
+	defer q.exit()
+	state := &tdbQueryProcState{proc: proc}
+	handle := cgo.NewHandle(state)
+	defer handle.Delete()
+	ok := C.tctdbqryproc(q.c_qry,
+		(C.TDBQRYPROC)(C.goTDBQueryProc),
+		unsafe.Pointer(&handle))
+	state.relay.raise()
+	if !ok {
+		err = q.db.LastError()
+	}
+	return
+}
+
+/* describes how the most recent search was executed */
+func (q *TDBQuery) Hint() string {
+	if q.enter() != nil {
+		return ""
+	}
+	defer q.exit()
+	return C.GoString(C.tctdbqryhint(q.c_qry))
+}
+
+/*
+ * combines the results of several queries with TDBMSUNION, TDBMSISECT or
+ * TDBMSDIFF. the ordering and limit of the first query apply to the result.
+ * queries on different databases fail with TCEINVALID, and a deleted one
+ * with ErrClosed.
+ */
+func MetaSearch(qrys []*TDBQuery, mstype int) (pkeys [][]byte, err error) {
+	if len(qrys) == 0 {
+		return [][]byte{}, nil
+	}
+	c_qrys := make([]*C.TDBQRY, len(qrys))
+	for i, q := range qrys {
+		if q.c_qry == nil {
+			return nil, ErrClosed
+		}
+		// only the first query's database is held during the search
+		if q.db.state != qrys[0].db.state {
+			return nil, NewTokyoCabinetError(TCINVALID, ECodeNameTDB(TCINVALID))
+		}
+		c_qrys[i] = q.c_qry
+	}
+	if err = qrys[0].db.state.enter(); err != nil {
+		return
+	}
+	defer qrys[0].db.state.exit()
+	resList := C.tctdbmetasearch(&c_qrys[0], C.int(len(c_qrys)), C.int(mstype))
+	// c_qrys alone doesn't stop the queries' cleanups from freeing them
+	runtime.KeepAlive(qrys)
+	defer C.tclistdel(resList)
+	pkeys = goList(resList)
+	return
+}
+
+type tdbQueryProcState struct {
+	proc  TDBQueryProc
+	relay panicRelay
+}
+
+//export goTDBQueryProc
+func goTDBQueryProc(pkbuf unsafe.Pointer, pksiz C.int, c_cols *C.TCMAP, op unsafe.Pointer) (res C.int) {
+	state := (*(*cgo.Handle)(op)).Value().(*tdbQueryProcState)
+	defer state.relay.catch(func() { res = C.int(TDBQPSTOP) })
+	cols := goMap(c_cols)
+	flags := state.proc(C.GoBytes(pkbuf, pksiz), cols)
+	if flags&TDBQPPUT != 0 {
+		C.tcmapclear(c_cols)
+		fillTCMap(c_cols, cols)
+	}
+	return C.int(flags)
+}
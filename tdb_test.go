@@ -0,0 +1,346 @@
+package tokyocabinet
+
+import "bytes"
+import "io/ioutil"
+import "os"
+import "testing"
+
+func tdb_assertOpen(t *testing.T, filename string, flags int) TDB {
+	var db TDB = *NewTDB()
+	if len(filename) == 0 {
+		tf, err := ioutil.TempFile("", "tctest")
+		if err != nil {
+			t.Fatalf("Unable to create temporary file: %s", err)
+		}
+		filename = tf.Name()
+	}
+	err := db.Open(filename, flags)
+	os.Remove(filename)
+	if err != nil {
+		t.Fatalf("Unable to open %s: %s", filename, err)
+	}
+	return db
+}
+
+func tdb_assertClose(t *testing.T, db TDB) {
+	err := db.Close()
+	if err != nil {
+		t.Fatalf("Unable to close database: %s", err)
+	}
+}
+
+func tdb_assertPut(t *testing.T, db TDB, key string, cols map[string]string) {
+	err := db.Put([]byte(key), tdb_cols(cols))
+	if err != nil {
+		t.Fatalf("Unable to assign key %s with columns %v: %s", key, cols, err)
+	}
+}
+
+func tdb_assertPutCat(t *testing.T, db TDB, key string, cols map[string]string) {
+	err := db.PutCat([]byte(key), tdb_cols(cols))
+	if err != nil {
+		t.Fatalf("Unable to assign key %s with columns %v: %s", key, cols, err)
+	}
+}
+
+func tdb_assertPutKeep(t *testing.T, db TDB, key string, cols map[string]string) {
+	err := db.PutKeep([]byte(key), tdb_cols(cols))
+	if err != nil {
+		t.Fatalf("Unable to assign key %s with columns %v: %s", key, cols, err)
+	}
+}
+
+func tdb_assertGetValue(t *testing.T, db TDB, key string, expected map[string]string) {
+	cols, err := db.Get([]byte(key))
+	if err != nil {
+		t.Fatalf("Unable to retrieve columns for key %s: %s", key, err)
+	}
+	if len(cols) != len(expected) {
+		t.Fatalf("Columns for key %s came back incorrect (expected: %v; got: %q)", key, expected, cols)
+	}
+	for name, value := range expected {
+		if bytes.Compare([]byte(value), cols[name]) != 0 {
+			t.Fatalf("Column %s for key %s came back incorrect (expected: %s; got: %s)", name, key, []byte(value), cols[name])
+		}
+	}
+}
+
+func tdb_assertRemove(t *testing.T, db TDB, key string) {
+	err := db.Remove([]byte(key))
+	if err != nil {
+		t.Fatalf("Unable to remove key %s: %s", key, err)
+	}
+}
+
+func tdb_assertBeginTxn(t *testing.T, db TDB) {
+	err := db.BeginTxn()
+	if err != nil {
+		t.Fatalf("Unable to begin transaction: %s", err)
+	}
+}
+
+func tdb_assertCommitTxn(t *testing.T, db TDB) {
+	err := db.CommitTxn()
+	if err != nil {
+		t.Fatalf("Unable to commit transaction: %s", err)
+	}
+}
+
+func tdb_assertAbortTxn(t *testing.T, db TDB) {
+	err := db.AbortTxn()
+	if err != nil {
+		t.Fatalf("Unable to abort transaction: %s", err)
+	}
+}
+
+func tdb_cols(cols map[string]string) map[string][]byte {
+	out := make(map[string][]byte, len(cols))
+	for name, value := range cols {
+		out[name] = []byte(value)
+	}
+	return out
+}
+
+func TestTDBPut(t *testing.T) {
+	db := tdb_assertOpen(t, "testput.tct", TDBOWRITER|TDBOCREAT|TDBOTRUNC)
+	defer tdb_assertClose(t, db)
+
+	// Put, PutCat
+	tdb_assertPut(t, db, "hello", map[string]string{"name": "world"})
+	tdb_assertGetValue(t, db, "hello", map[string]string{"name": "world"})
+	tdb_assertPutCat(t, db, "hello", map[string]string{"name": "ignored", "age": "42"})
+	tdb_assertGetValue(t, db, "hello", map[string]string{"name": "world", "age": "42"})
+
+	// PutKeep
+	tdb_assertPutKeep(t, db, "keep", map[string]string{"name": "first"})
+	tdb_assertPutKeep(t, db, "keep", map[string]string{"name": "second"})
+	tdb_assertGetValue(t, db, "keep", map[string]string{"name": "first"})
+
+	// Remove
+	tdb_assertRemove(t, db, "keep")
+	if _, err := db.Get([]byte("keep")); err == nil {
+		t.Fatalf("Expected an error retrieving removed key")
+	}
+}
+
+func TestTDBGenUID(t *testing.T) {
+	db := tdb_assertOpen(t, "testuid.tct", TDBOWRITER|TDBOCREAT|TDBOTRUNC)
+	defer tdb_assertClose(t, db)
+
+	first, err := db.GenUID()
+	if err != nil {
+		t.Fatalf("Unable to generate unique ID: %s", err)
+	}
+	second, err := db.GenUID()
+	if err != nil {
+		t.Fatalf("Unable to generate unique ID: %s", err)
+	}
+	if second <= first {
+		t.Fatalf("Expected increasing unique IDs, got %d then %d", first, second)
+	}
+}
+
+func TestTDBTransactions(t *testing.T) {
+	db := tdb_assertOpen(t, "testtxn.tct", TDBOWRITER|TDBOCREAT|TDBOTRUNC)
+	defer tdb_assertClose(t, db)
+
+	tdb_assertPut(t, db, "txn-1", map[string]string{"v": "set-outside-txn"})
+	tdb_assertBeginTxn(t, db)
+	tdb_assertPut(t, db, "txn-1", map[string]string{"v": "set-inside-txn"})
+	tdb_assertAbortTxn(t, db)
+	tdb_assertGetValue(t, db, "txn-1", map[string]string{"v": "set-outside-txn"})
+
+	tdb_assertPut(t, db, "txn-2", map[string]string{"v": "set-outside-txn"})
+	tdb_assertBeginTxn(t, db)
+	tdb_assertPut(t, db, "txn-2", map[string]string{"v": "set-inside-txn"})
+	tdb_assertCommitTxn(t, db)
+	tdb_assertGetValue(t, db, "txn-2", map[string]string{"v": "set-inside-txn"})
+}
+
+func tdb_assertSearch(t *testing.T, q *TDBQuery, expectedKeys []string) {
+	tdb_assertKeys(t, q.Search(), expectedKeys)
+}
+
+func tdb_assertKeys(t *testing.T, pkeys [][]byte, expectedKeys []string) {
+	if len(pkeys) != len(expectedKeys) {
+		t.Fatalf("Expected %d keys, found %d in search results", len(expectedKeys), len(pkeys))
+	}
+	for i := 0; i < len(expectedKeys); i++ {
+		if string(pkeys[i]) != expectedKeys[i] {
+			t.Fatalf("Expected key %s at position %d, got %s", expectedKeys[i], i, pkeys[i])
+		}
+	}
+}
+
+func tdb_putPeople(t *testing.T, db TDB) {
+	tdb_assertPut(t, db, "1", map[string]string{"name": "alice", "age": "31", "tags": "admin staff"})
+	tdb_assertPut(t, db, "2", map[string]string{"name": "bob", "age": "25", "tags": "staff"})
+	tdb_assertPut(t, db, "3", map[string]string{"name": "carol", "age": "47", "tags": "guest"})
+}
+
+func TestTDBQuery(t *testing.T) {
+	db := tdb_assertOpen(t, "testqry.tct", TDBOWRITER|TDBOCREAT|TDBOTRUNC)
+	defer tdb_assertClose(t, db)
+	tdb_putPeople(t, db)
+
+	q := NewTDBQuery(&db)
+	defer q.Del()
+	q.AddCond("age", TDBQCNUMGE, "30")
+	q.SetOrder("age", TDBQONUMDESC)
+	tdb_assertSearch(t, q, []string{"3", "1"})
+
+	q = NewTDBQuery(&db)
+	defer q.Del()
+	q.AddCond("tags", TDBQCSTRAND, "staff")
+	q.AddCond("name", TDBQCSTREQ|TDBQCNEGATE, "bob")
+	tdb_assertSearch(t, q, []string{"1"})
+
+	q = NewTDBQuery(&db)
+	defer q.Del()
+	q.SetOrder("name", TDBQOSTRASC)
+	q.SetLimit(1, 1)
+	tdb_assertSearch(t, q, []string{"2"})
+}
+
+func TestTDBQuerySearchOut(t *testing.T) {
+	db := tdb_assertOpen(t, "testqryout.tct", TDBOWRITER|TDBOCREAT|TDBOTRUNC)
+	defer tdb_assertClose(t, db)
+	tdb_putPeople(t, db)
+
+	q := NewTDBQuery(&db)
+	defer q.Del()
+	q.AddCond("tags", TDBQCSTRAND, "staff")
+	if err := q.SearchOut(); err != nil {
+		t.Fatalf("Unable to remove search results: %s", err)
+	}
+
+	all := NewTDBQuery(&db)
+	defer all.Del()
+	tdb_assertSearch(t, all, []string{"3"})
+}
+
+func TestTDBQueryProc(t *testing.T) {
+	db := tdb_assertOpen(t, "testqryproc.tct", TDBOWRITER|TDBOCREAT|TDBOTRUNC)
+	defer tdb_assertClose(t, db)
+	tdb_putPeople(t, db)
+
+	q := NewTDBQuery(&db)
+	defer q.Del()
+	err := q.Proc(func(pkey []byte, cols map[string][]byte) int {
+		switch string(cols["name"]) {
+		case "alice":
+			cols["name"] = []byte("alicia")
+			return TDBQPPUT
+		case "bob":
+			return TDBQPOUT
+		}
+		return 0
+	})
+	if err != nil {
+		t.Fatalf("Unable to process query results: %s", err)
+	}
+
+	tdb_assertGetValue(t, db, "1", map[string]string{"name": "alicia", "age": "31", "tags": "admin staff"})
+	if _, err := db.Get([]byte("2")); err == nil {
+		t.Fatalf("Expected row removed by query processor to be gone")
+	}
+	tdb_assertGetValue(t, db, "3", map[string]string{"name": "carol", "age": "47", "tags": "guest"})
+
+	func() {
+		defer func() {
+			if recover() != "failure" {
+				t.Fatalf("Expected the panic to propagate")
+			}
+		}()
+		q.Proc(func(pkey []byte, cols map[string][]byte) int {
+			panic("failure")
+		})
+	}()
+	// the database must not be left locked by the panic
+	tdb_assertGetValue(t, db, "3", map[string]string{"name": "carol", "age": "47", "tags": "guest"})
+}
+
+func tdb_assertSetIndex(t *testing.T, db TDB, name string, itype int) {
+	err := db.SetIndex(name, itype)
+	if err != nil {
+		t.Fatalf("Unable to set index on column %s: %s", name, err)
+	}
+}
+
+func tdb_assertIndexes(t *testing.T, db TDB, expected map[string]int) {
+	idxs := db.Indexes()
+	if len(idxs) != len(expected) {
+		t.Fatalf("Expected %d indexes, found %d", len(expected), len(idxs))
+	}
+	for _, idx := range idxs {
+		itype, ok := expected[idx.Name]
+		if !ok || itype != idx.Type {
+			t.Fatalf("Unexpected index %s of type %d", idx.Name, idx.Type)
+		}
+	}
+}
+
+func TestTDBIndex(t *testing.T) {
+	db := tdb_assertOpen(t, "testidx.tct", TDBOWRITER|TDBOCREAT|TDBOTRUNC)
+	defer tdb_assertClose(t, db)
+	tdb_putPeople(t, db)
+
+	tdb_assertSetIndex(t, db, "name", TDBITLEXICAL)
+	tdb_assertSetIndex(t, db, "age", TDBITDECIMAL)
+	tdb_assertSetIndex(t, db, "tags", TDBITTOKEN)
+	tdb_assertSetIndex(t, db, "name", TDBITLEXICAL|TDBITKEEP)
+	tdb_assertIndexes(t, db, map[string]int{
+		"name": TDBITLEXICAL,
+		"age":  TDBITDECIMAL,
+		"tags": TDBITTOKEN,
+	})
+
+	q := NewTDBQuery(&db)
+	defer q.Del()
+	q.AddCond("age", TDBQCNUMGE, "30")
+	q.SetOrder("age", TDBQONUMASC)
+	tdb_assertSearch(t, q, []string{"1", "3"})
+
+	tdb_assertSetIndex(t, db, "age", TDBITOPT)
+	tdb_assertSetIndex(t, db, "tags", TDBITVOID)
+	tdb_assertIndexes(t, db, map[string]int{
+		"name": TDBITLEXICAL,
+		"age":  TDBITDECIMAL,
+	})
+}
+
+func tdb_assertMetaSearch(t *testing.T, qrys []*TDBQuery, mstype int, expected []string) {
+	pkeys, err := MetaSearch(qrys, mstype)
+	if err != nil {
+		t.Fatalf("Unable to run meta search: %s", err)
+	}
+	tdb_assertKeys(t, pkeys, expected)
+}
+
+func TestTDBMetaSearch(t *testing.T) {
+	db := tdb_assertOpen(t, "testmeta.tct", TDBOWRITER|TDBOCREAT|TDBOTRUNC)
+	defer tdb_assertClose(t, db)
+	tdb_putPeople(t, db)
+
+	staff := NewTDBQuery(&db)
+	defer staff.Del()
+	staff.AddCond("tags", TDBQCSTRAND, "staff")
+	staff.SetOrder("", TDBQOSTRASC)
+
+	older := NewTDBQuery(&db)
+	defer older.Del()
+	older.AddCond("age", TDBQCNUMGT, "30")
+
+	qrys := []*TDBQuery{staff, older}
+	tdb_assertMetaSearch(t, qrys, TDBMSUNION, []string{"1", "2", "3"})
+	tdb_assertMetaSearch(t, qrys, TDBMSISECT, []string{"1"})
+	tdb_assertMetaSearch(t, qrys, TDBMSDIFF, []string{"2"})
+
+	other := tdb_assertOpen(t, "testmeta2.tct", TDBOWRITER|TDBOCREAT|TDBOTRUNC)
+	defer tdb_assertClose(t, other)
+	foreign := NewTDBQuery(&other)
+	defer foreign.Del()
+	if pkeys, err := MetaSearch([]*TDBQuery{staff, foreign}, TDBMSUNION); err == nil {
+		t.Fatalf("Expected an error for queries on different databases, got %q", pkeys)
+	}
+}
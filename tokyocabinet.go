@@ -4,6 +4,12 @@ package tokyocabinet
 // #include <tcutil.h>
 import "C"
 
+import (
+	"runtime/cgo"
+	"sync/atomic"
+	"unsafe"
+)
+
 const TCESUCCESS int = C.TCESUCCESS
 const TCETHREAD int = C.TCETHREAD
 const TCINVALID int = C.TCEINVALID
@@ -37,3 +43,111 @@ const TCDBTTABLE int = C.TCDBTTABLE
 func ECodeName(ecode int) string {
 	return C.GoString(C.tcerrmsg(C.int(ecode)))
 }
+
+/* copies the contents of a TCLIST; the list itself is not released */
+func goList(c_list *C.TCLIST) (out [][]byte) {
+	num := int(C.tclistnum(c_list))
+	out = make([][]byte, 0, num)
+	for i := 0; i < num; i++ {
+		var size C.int
+		val := C.tclistval(c_list, C.int(i), &size)
+		out = append(out, C.GoBytes(val, size))
+	}
+	return
+}
+
+/* the returned list must be released with tclistdel */
+func newTCList(vals [][]byte) *C.TCLIST {
+	c_list := C.tclistnew2(C.int(len(vals)))
+	for _, val := range vals {
+		C.tclistpush(c_list, bytesPtr(val), C.int(len(val)))
+	}
+	return c_list
+}
+
+var emptyBytes [1]byte
+
+/*
+ * the address of b's data for passing along with len(b). the library rejects
+ * null buffers, so empty and nil slices point at a shared zero byte instead.
+ */
+func bytesPtr(b []byte) unsafe.Pointer {
+	if len(b) == 0 {
+		return unsafe.Pointer(&emptyBytes[0])
+	}
+	return unsafe.Pointer(&b[0])
+}
+
+/*
+ * an op pointer for the library to hand back to a callback, holding v. it
+ * lives in C memory since the library keeps it; free it with freeCallbackOp.
+ */
+func newCallbackOp(v any) unsafe.Pointer {
+	handle := cgo.NewHandle(v)
+	c_op := C.malloc(C.size_t(unsafe.Sizeof(handle)))
+	*(*cgo.Handle)(c_op) = handle
+	return c_op
+}
+
+func callbackValue(c_op unsafe.Pointer) any {
+	return (*(*cgo.Handle)(c_op)).Value()
+}
+
+func freeCallbackOp(c_op unsafe.Pointer) {
+	(*(*cgo.Handle)(c_op)).Delete()
+	C.free(c_op)
+}
+
+/*
+ * carries a panic out of a callback. unwinding through the library would
+ * leave its locks held and its structures half updated, so callbacks
+ * recover into a relay and return to the library normally; the panic is
+ * raised again once the native call is done. the first panic wins.
+ */
+type panicRelay struct {
+	value atomic.Pointer[any]
+}
+
+/* deferred by the callback; on a panic, stop sets the result it returns */
+func (r *panicRelay) catch(stop func()) {
+	if v := recover(); v != nil {
+		r.value.CompareAndSwap(nil, &v)
+		stop()
+	}
+}
+
+/* raises the panic caught since the last call, if any */
+func (r *panicRelay) raise() {
+	if r.value.Load() == nil {
+		return
+	}
+	if v := r.value.Swap(nil); v != nil {
+		panic(*v)
+	}
+}
+
+/*
+ * the op pointer currently registered with the library. it is kept apart
+ * from the database so the cleanup of a leaked handle can free it without
+ * referring to the database.
+ */
+type callbackOp struct {
+	c_op unsafe.Pointer
+}
+
+/* frees the previous op, if any */
+func (c *callbackOp) replace(c_op unsafe.Pointer) {
+	c.release()
+	c.c_op = c_op
+}
+
+func (c *callbackOp) isSet() bool {
+	return c != nil && c.c_op != nil
+}
+
+func (c *callbackOp) release() {
+	if c != nil && c.c_op != nil {
+		freeCallbackOp(c.c_op)
+		c.c_op = nil
+	}
+}
@@ -0,0 +1,86 @@
+package tokyocabinet
+
+import "errors"
+
+/* returned by the write methods of a transaction started by View */
+var ErrReadOnlyTx = errors.New("tokyocabinet: write in a read-only transaction")
+
+/* the unlocked operations a Tx is built from */
+type txStore interface {
+	begin() error
+	commit() error
+	abort() error
+	get(key []byte) ([]byte, error)
+	put(key []byte, value []byte) error
+	remove(key []byte) error
+}
+
+/*
+ * a transaction started by Update or View, valid only until the function it
+ * was passed to returns. the database is held exclusively in the meantime, so
+ * the function must go through tx: calling methods of the database itself
+ * blocks forever. for FDB, keys are decimal strings as with FDBStore.
+ */
+type Tx struct {
+	store    txStore
+	writable bool
+}
+
+func (tx *Tx) Get(key []byte) ([]byte, error) {
+	if tx.store == nil {
+		return nil, ErrClosed
+	}
+	return tx.store.get(key)
+}
+
+func (tx *Tx) Put(key []byte, value []byte) error {
+	if tx.store == nil {
+		return ErrClosed
+	}
+	if !tx.writable {
+		return ErrReadOnlyTx
+	}
+	return tx.store.put(key, value)
+}
+
+func (tx *Tx) Remove(key []byte) error {
+	if tx.store == nil {
+		return ErrClosed
+	}
+	if !tx.writable {
+		return ErrReadOnlyTx
+	}
+	return tx.store.remove(key)
+}
+
+/*
+ * runs fn holding h exclusively. a writable transaction is committed if fn
+ * returns nil and aborted if it returns an error or panics.
+ */
+func runTx(h *handle, store txStore, writable bool, fn func(tx *Tx) error) (err error) {
+	if err = h.lock(); err != nil {
+		return
+	}
+	defer h.unlock()
+	if writable {
+		if err = store.begin(); err != nil {
+			return
+		}
+	}
+	tx := &Tx{store, writable}
+	done := false
+	defer func() {
+		tx.store = nil
+		if writable && !done {
+			store.abort()
+		}
+	}()
+	if err = fn(tx); err != nil {
+		return
+	}
+	done = true
+	if writable {
+		err = store.commit()
+	}
+	return
+}
@@ -0,0 +1,135 @@
+package tokyocabinet
+
+import "errors"
+import "testing"
+import "time"
+
+type tx_db interface {
+	Update(fn func(tx *Tx) error) error
+	View(fn func(tx *Tx) error) error
+}
+
+func tx_assertGetValue(t *testing.T, db tx_db, key string, expected string) {
+	err := db.View(func(tx *Tx) error {
+		value, err := tx.Get([]byte(key))
+		if err != nil {
+			return err
+		}
+		if string(value) != expected {
+			t.Fatalf("Value for key %s came back incorrect (expected: %s; got: %s)", key, expected, value)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Unable to retrieve value for key %s: %s", key, err)
+	}
+}
+
+func tx_assertMissing(t *testing.T, db tx_db, key string) {
+	err := db.View(func(tx *Tx) error {
+		_, err := tx.Get([]byte(key))
+		return err
+	})
+	if !errors.Is(err, ErrNoRecord) {
+		t.Fatalf("Expected key %s to be missing, got %v", key, err)
+	}
+}
+
+/* s is the same database as db, used to write from outside the transactions */
+func tx_exercise(t *testing.T, db tx_db, s Store) {
+	err := db.Update(func(tx *Tx) error {
+		return tx.Put([]byte("1"), []byte("committed"))
+	})
+	if err != nil {
+		t.Fatalf("Unable to update: %s", err)
+	}
+	tx_assertGetValue(t, db, "1", "committed")
+
+	failure := errors.New("failure")
+	err = db.Update(func(tx *Tx) error {
+		if err := tx.Put([]byte("2"), []byte("aborted")); err != nil {
+			t.Fatalf("Unable to put: %s", err)
+		}
+		if err := tx.Remove([]byte("1")); err != nil {
+			t.Fatalf("Unable to remove: %s", err)
+		}
+		return failure
+	})
+	if err != failure {
+		t.Fatalf("Expected the error returned by the function, got %v", err)
+	}
+	tx_assertMissing(t, db, "2")
+	tx_assertGetValue(t, db, "1", "committed")
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("Expected the panic to propagate")
+			}
+		}()
+		db.Update(func(tx *Tx) error {
+			tx.Put([]byte("2"), []byte("panicked"))
+			panic("failure")
+		})
+	}()
+	tx_assertMissing(t, db, "2")
+
+	var leaked *Tx
+	err = db.View(func(tx *Tx) error {
+		leaked = tx
+		return tx.Put([]byte("2"), []byte("read only"))
+	})
+	if !errors.Is(err, ErrReadOnlyTx) {
+		t.Fatalf("Expected ErrReadOnlyTx, got %v", err)
+	}
+	if _, err := leaked.Get([]byte("1")); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Expected ErrClosed from a finished transaction, got %v", err)
+	}
+
+	written := make(chan error, 1)
+	err = db.Update(func(tx *Tx) error {
+		go func() {
+			written <- s.Put([]byte("3"), []byte("outside"))
+		}()
+		select {
+		case err := <-written:
+			t.Fatalf("Expected a write outside the transaction to block, got %v", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+		if _, err := tx.Get([]byte("3")); !errors.Is(err, ErrNoRecord) {
+			t.Fatalf("Expected the outside write not to join the transaction, got %v", err)
+		}
+		return tx.Put([]byte("4"), []byte("inside"))
+	})
+	if err != nil {
+		t.Fatalf("Unable to update: %s", err)
+	}
+	if err := <-written; err != nil {
+		t.Fatalf("Unable to put outside the transaction: %s", err)
+	}
+	tx_assertGetValue(t, db, "3", "outside")
+	tx_assertGetValue(t, db, "4", "inside")
+}
+
+func TestTx(t *testing.T) {
+	hdb := hdb_assertOpen(t, "testtx.hdb", HDBOWRITER|HDBOCREAT|HDBOTRUNC)
+	tx_exercise(t, &hdb, NewHDBStore(&hdb))
+	hdb_assertClose(t, hdb)
+
+	bdb := bdb_assertOpen(t, "testtx.bdb", BDBOWRITER|BDBOCREAT|BDBOTRUNC)
+	tx_exercise(t, &bdb, NewBDBStore(&bdb))
+	bdb_assertClose(t, bdb)
+
+	fdb := fdb_assertOpen(t, "testtx.fdb", FDBOWRITER|FDBOCREAT|FDBOTRUNC)
+	tx_exercise(t, &fdb, NewFDBStore(&fdb))
+	fdb_assertClose(t, fdb)
+
+	adb := adb_assertOpen(t, "testtx.tch")
+	tx_exercise(t, &adb, &adb)
+	adb_assertClose(t, adb)
+
+	var closed HDB
+	if err := closed.Update(func(tx *Tx) error { return nil }); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Expected ErrClosed from a zero HDB, got %v", err)
+	}
+}